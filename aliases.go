@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Kinds of names in the alias dictionary, which are also the names of
+// the fields they are normalized into
+const (
+	aliasActor  = "actor"
+	aliasFamily = "malware_family"
+)
+
+// Canonical names of actors and malware families by alias. The dictionary
+// file has one line per actor or family, like
+//
+//	actor,APT29,Cozy Bear,The Dukes
+//	family,Emotet,Geodo,Heodo
+//
+// where the first name is the canonical one. Lines starting with # are
+// comments.
+type aliasDictionaryType map[string]map[string]string
+
+var aliasDictionary = aliasDictionaryType{aliasActor: {}, aliasFamily: {}}
+
+// aliasKey ignores case, spaces and punctuation so that Cozy Bear,
+// cozy_bear and CozyBear are the same alias.
+func aliasKey(name string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+func readAliases(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ",")
+		kind := strings.TrimSpace(parts[0])
+		if kind == "family" {
+			kind = aliasFamily
+		}
+		if aliasDictionary[kind] == nil || len(parts) < 2 {
+			return fmt.Errorf("%s:%d: expected actor or family followed by names", filename, n+1)
+		}
+		canonical := strings.TrimSpace(parts[1])
+		for _, alias := range parts[1:] {
+			key := aliasKey(alias)
+			if key != "" {
+				aliasDictionary[kind][key] = canonical
+			}
+		}
+	}
+	return nil
+}
+
+func (dictionary aliasDictionaryType) lookup(kind string, name string) (string, bool) {
+	canonical, ok := dictionary[kind][aliasKey(name)]
+	return canonical, ok
+}
+
+// aliasMetaKind returns the kind of name a metadata key holds, if any.
+func aliasMetaKind(key string) string {
+	switch {
+	case strings.Contains(key, "actor") || key == "apt_group" || key == "threat_group":
+		return aliasActor
+	case strings.Contains(key, "family") || key == "malware":
+		return aliasFamily
+	}
+	return ""
+}
+
+func splitAliasValues(value string) []string {
+	values := []string{}
+	for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' || r == '|' || r == '/' }) {
+		part = strings.TrimSpace(part)
+		if part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// Actors and families of a rule, and the names in its metadata that are
+// not in the dictionary.
+type ruleAliasesType struct {
+	names    map[string]MapSet
+	unmapped map[string][]string
+}
+
+// extractAliases normalizes the actors and malware families named in the
+// metadata, tags and rule name tags of a rule. Names in actor and family
+// metadata are kept as they are when they are not in the dictionary.
+// Adjacent rule name tags are also looked up together, as in Cozy_Bear.
+func extractAliases(doc *yaraRuleType) *ruleAliasesType {
+	aliases := &ruleAliasesType{
+		names:    map[string]MapSet{aliasActor: {}, aliasFamily: {}},
+		unmapped: map[string][]string{},
+	}
+	for key, values := range doc.Metadata {
+		kind := aliasMetaKind(key)
+		if kind == "" {
+			continue
+		}
+		for _, value := range values {
+			for _, name := range splitAliasValues(value) {
+				canonical, ok := aliasDictionary.lookup(kind, name)
+				if !ok {
+					canonical = name
+					aliases.unmapped[kind] = append(aliases.unmapped[kind], name)
+				}
+				aliases.names[kind].Add(canonical)
+			}
+		}
+	}
+
+	candidates := append([]string{}, doc.RuleTags...)
+	for i := range doc.RuleNameTags {
+		for j := i + 1; j <= len(doc.RuleNameTags) && j <= i+3; j++ {
+			candidates = append(candidates, strings.Join(doc.RuleNameTags[i:j], ""))
+		}
+	}
+	for _, candidate := range candidates {
+		for _, kind := range []string{aliasActor, aliasFamily} {
+			if canonical, ok := aliasDictionary.lookup(kind, candidate); ok {
+				aliases.names[kind].Add(canonical)
+			}
+		}
+	}
+	return aliases
+}
+
+func setRuleAliases(doc *yaraRuleType, aliases *ruleAliasesType) {
+	doc.Actor = sortedKeys(aliases.names[aliasActor])
+	doc.MalwareFamily = sortedKeys(aliases.names[aliasFamily])
+}
+
+// Name found in actor or family metadata without a dictionary entry.
+type unmappedAliasType struct {
+	Name  string
+	Rules int
+}
+
+// unmappedAliases returns the names of a kind in the metadata of rules
+// that are not in the dictionary, most frequent first.
+func unmappedAliases(docs []*yaraRuleType, kind string) []*unmappedAliasType {
+	counts := map[string]int{}
+	for _, doc := range docs {
+		seen := MapSet{}
+		for _, name := range extractAliases(doc).unmapped[kind] {
+			if !seen.Contains(name) {
+				seen.Add(name)
+				counts[name]++
+			}
+		}
+	}
+	result := []*unmappedAliasType{}
+	for name, count := range counts {
+		result = append(result, &unmappedAliasType{name, count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Rules != result[j].Rules {
+			return result[i].Rules > result[j].Rules
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
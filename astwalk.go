@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/VirusTotal/gyp/ast"
+)
+
+// childNodes returns the children of an AST node. The Children methods of
+// nodes that embed an expression, like Group and Not, return the children
+// of the embedded expression instead of the expression itself.
+func childNodes(node ast.Node) []ast.Node {
+	switch n := node.(type) {
+	case *ast.Group:
+		return []ast.Node{n.Expression}
+	case *ast.Not:
+		return []ast.Node{n.Expression}
+	case *ast.Minus:
+		return []ast.Node{n.Expression}
+	case *ast.BitwiseNot:
+		return []ast.Node{n.Expression}
+	case *ast.Quantifier:
+		return []ast.Node{n.Expression}
+	}
+	return node.Children()
+}
+
+// walkNode calls visit for node and all of its descendants, parents
+// before children.
+func walkNode(node ast.Node, visit func(ast.Node)) {
+	if node == nil {
+		return
+	}
+	visit(node)
+	for _, child := range childNodes(node) {
+		walkNode(child, visit)
+	}
+}
+
+// rootIdentifier returns the identifier at the root of a member access,
+// subscript or function call, like "pe" for pe.sections[0].name.
+func rootIdentifier(expression ast.Expression) string {
+	for {
+		switch e := expression.(type) {
+		case *ast.Identifier:
+			return e.Identifier
+		case *ast.MemberAccess:
+			expression = e.Container
+		case *ast.Subscripting:
+			expression = e.Array
+		case *ast.FunctionCall:
+			expression = e.Callable
+		default:
+			return ""
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+type attackTacticType struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Shortname string `json:"shortname"`
+}
+
+type attackTechniqueType struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Tactics []string `json:"tactics"`
+}
+
+// ATT&CK tactics and techniques IDs found in rules are checked against.
+type attackDatasetType struct {
+	tactics     []*attackTacticType
+	byShortname map[string]*attackTacticType
+	byTacticID  map[string]*attackTacticType
+	techniques  map[string]*attackTechniqueType
+	// Lowercase names and short names of techniques and tactics
+	names map[string]string
+}
+
+var (
+	attackDataset = newAttackDataset(builtinAttackTactics, builtinAttackTechniques)
+
+	attackTokenRE     = regexp.MustCompile(`[A-Za-z0-9]+`)
+	attackTechniqueRE = regexp.MustCompile(`^[Tt]\d{4}$`)
+	attackSubRE       = regexp.MustCompile(`^\d{3}$`)
+	attackTacticRE    = regexp.MustCompile(`^[Tt][Aa]\d{4}$`)
+)
+
+// Metadata keys with any of these words, like mitre_attack or ttps, may
+// hold ATT&CK IDs or names
+var attackMetaKeys = []string{"mitre", "attack", "technique", "tactic", "ttp"}
+
+func newAttackDataset(tactics []*attackTacticType, techniques []*attackTechniqueType) *attackDatasetType {
+	dataset := &attackDatasetType{
+		tactics:     tactics,
+		byShortname: map[string]*attackTacticType{},
+		byTacticID:  map[string]*attackTacticType{},
+		techniques:  map[string]*attackTechniqueType{},
+		names:       map[string]string{},
+	}
+	for _, tactic := range tactics {
+		dataset.byShortname[tactic.Shortname] = tactic
+		dataset.byTacticID[tactic.ID] = tactic
+		dataset.names[strings.ToLower(tactic.Name)] = tactic.ID
+		dataset.names[tactic.Shortname] = tactic.ID
+	}
+	for _, technique := range techniques {
+		dataset.techniques[technique.ID] = technique
+		// Sub-technique names like DNS are too ambiguous to look up
+		if !strings.Contains(technique.ID, ".") {
+			dataset.names[strings.ToLower(technique.Name)] = technique.ID
+		}
+	}
+	return dataset
+}
+
+// loadAttackBundle reads the tactics and techniques of an ATT&CK STIX
+// bundle like enterprise-attack.json. Revoked and deprecated techniques
+// are left out.
+func loadAttackBundle(filename string) (*attackDatasetType, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	bundle := struct {
+		Objects []struct {
+			Type               string `json:"type"`
+			Name               string `json:"name"`
+			Shortname          string `json:"x_mitre_shortname"`
+			Revoked            bool   `json:"revoked"`
+			Deprecated         bool   `json:"x_mitre_deprecated"`
+			ExternalReferences []struct {
+				SourceName string `json:"source_name"`
+				ExternalID string `json:"external_id"`
+			} `json:"external_references"`
+			KillChainPhases []struct {
+				KillChainName string `json:"kill_chain_name"`
+				PhaseName     string `json:"phase_name"`
+			} `json:"kill_chain_phases"`
+		} `json:"objects"`
+	}{}
+	err = json.Unmarshal(data, &bundle)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a STIX bundle: %v", filename, err)
+	}
+
+	tactics := []*attackTacticType{}
+	techniques := []*attackTechniqueType{}
+	for _, object := range bundle.Objects {
+		if object.Revoked || object.Deprecated {
+			continue
+		}
+		id := ""
+		for _, reference := range object.ExternalReferences {
+			if reference.SourceName == "mitre-attack" {
+				id = reference.ExternalID
+			}
+		}
+		if id == "" {
+			continue
+		}
+		switch object.Type {
+		case "x-mitre-tactic":
+			tactics = append(tactics, &attackTacticType{id, object.Name, object.Shortname})
+		case "attack-pattern":
+			technique := &attackTechniqueType{ID: id, Name: object.Name}
+			for _, phase := range object.KillChainPhases {
+				if phase.KillChainName == "mitre-attack" {
+					technique.Tactics = append(technique.Tactics, phase.PhaseName)
+				}
+			}
+			techniques = append(techniques, technique)
+		}
+	}
+	if len(tactics) == 0 || len(techniques) == 0 {
+		return nil, fmt.Errorf("%s has no ATT&CK tactics or techniques", filename)
+	}
+	sort.Slice(tactics, func(i, j int) bool { return tactics[i].ID < tactics[j].ID })
+	return newAttackDataset(tactics, techniques), nil
+}
+
+// technique returns the technique with the given ID. Sub-techniques
+// missing from the dataset take the name and tactics of their technique.
+func (dataset *attackDatasetType) technique(id string) *attackTechniqueType {
+	if technique, ok := dataset.techniques[id]; ok {
+		return technique
+	}
+	parts := strings.SplitN(id, ".", 2)
+	if len(parts) == 2 {
+		if parent, ok := dataset.techniques[parts[0]]; ok {
+			return &attackTechniqueType{ID: id, Name: parent.Name, Tactics: parent.Tactics}
+		}
+	}
+	return nil
+}
+
+// findIDs adds the known technique and tactic IDs in s to techniques and
+// tactics. IDs may be written like T1055.012, t1055_012 or TA0005.
+func (dataset *attackDatasetType) findIDs(s string, techniques MapSet, tactics MapSet) {
+	tokens := attackTokenRE.FindAllString(s, -1)
+	for i, token := range tokens {
+		switch {
+		case attackTacticRE.MatchString(token):
+			id := strings.ToUpper(token)
+			if _, ok := dataset.byTacticID[id]; ok {
+				tactics.Add(id)
+			}
+		case attackTechniqueRE.MatchString(token):
+			id := strings.ToUpper(token)
+			if i+1 < len(tokens) && attackSubRE.MatchString(tokens[i+1]) && dataset.technique(id+"."+tokens[i+1]) != nil {
+				id += "." + tokens[i+1]
+			}
+			if dataset.technique(id) != nil {
+				techniques.Add(id)
+			}
+		}
+	}
+}
+
+// findName adds the technique or tactic named s, if any.
+func (dataset *attackDatasetType) findName(s string, techniques MapSet, tactics MapSet) {
+	name := strings.ToLower(strings.TrimSpace(s))
+	for _, candidate := range []string{name, strings.ReplaceAll(name, "_", " "), strings.ReplaceAll(name, "_", "-")} {
+		id, ok := dataset.names[candidate]
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(id, "TA") {
+			tactics.Add(id)
+		} else {
+			techniques.Add(id)
+		}
+		return
+	}
+}
+
+// isAttackMetaKey matches whole words of the key, in singular or plural,
+// so http_url does not count as a ttp key.
+func isAttackMetaKey(key string) bool {
+	words := strings.FieldsFunc(strings.ToLower(key), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		for _, part := range attackMetaKeys {
+			if word == part || word == part+"s" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extractAttack returns the ATT&CK techniques and tactics of a rule, from
+// its ATT&CK related metadata, tags and name. Sub-techniques also count as
+// their technique, and techniques as their tactics.
+func extractAttack(doc *yaraRuleType) ([]string, []string) {
+	techniques, tactics := MapSet{}, MapSet{}
+	for key, values := range doc.Metadata {
+		if !isAttackMetaKey(key) {
+			continue
+		}
+		for _, value := range values {
+			attackDataset.findIDs(value, techniques, tactics)
+			for _, part := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ';' || r == '|' }) {
+				attackDataset.findName(part, techniques, tactics)
+			}
+		}
+	}
+	for _, tag := range doc.RuleTags {
+		attackDataset.findIDs(tag, techniques, tactics)
+		attackDataset.findName(tag, techniques, tactics)
+	}
+	attackDataset.findIDs(doc.RuleName, techniques, tactics)
+
+	for id := range techniques {
+		if i := strings.Index(id, "."); i > 0 {
+			techniques.Add(id[:i])
+		}
+	}
+	for id := range techniques {
+		technique := attackDataset.technique(id)
+		if technique == nil {
+			continue
+		}
+		for _, shortname := range technique.Tactics {
+			if tactic, ok := attackDataset.byShortname[shortname]; ok {
+				tactics.Add(tactic.ID)
+			}
+		}
+	}
+	return sortedKeys(techniques), sortedKeys(tactics)
+}
+
+type techniqueCoverageType struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Tactics []string `json:"tactics"`
+	Rules   int      `json:"rules"`
+}
+
+type tacticCoverageType struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Rules      int    `json:"rules"`
+	Techniques int    `json:"techniques"`
+	Covered    int    `json:"covered"`
+}
+
+// Number of rules per ATT&CK technique and tactic.
+type attackCoverageType struct {
+	Rules      int                      `json:"rules"`
+	Mapped     int                      `json:"mapped"`
+	Tactics    []*tacticCoverageType    `json:"tactics"`
+	Techniques []*techniqueCoverageType `json:"techniques"`
+}
+
+// attackCoverage counts the rules mapped to each technique and tactic of
+// the dataset. Sub-techniques are listed when they are in the dataset or
+// some rule maps to them.
+func attackCoverage(docs []*yaraRuleType) *attackCoverageType {
+	coverage := &attackCoverageType{Rules: len(docs)}
+	techniqueRules := map[string]int{}
+	tacticRules := map[string]int{}
+	for _, doc := range docs {
+		if len(doc.AttackTechniques) > 0 || len(doc.AttackTactics) > 0 {
+			coverage.Mapped++
+		}
+		for _, id := range doc.AttackTechniques {
+			techniqueRules[id]++
+		}
+		for _, id := range doc.AttackTactics {
+			tacticRules[id]++
+		}
+	}
+
+	ids := MapSet{}
+	for id := range attackDataset.techniques {
+		ids.Add(id)
+	}
+	for id := range techniqueRules {
+		if attackDataset.technique(id) != nil {
+			ids.Add(id)
+		}
+	}
+	tacticTechniques := map[string]int{}
+	tacticCovered := map[string]int{}
+	for _, id := range sortedKeys(ids) {
+		technique := attackDataset.technique(id)
+		coverage.Techniques = append(coverage.Techniques, &techniqueCoverageType{
+			ID:      id,
+			Name:    technique.Name,
+			Tactics: technique.Tactics,
+			Rules:   techniqueRules[id],
+		})
+		for _, tactic := range technique.Tactics {
+			tacticTechniques[tactic]++
+			if techniqueRules[id] > 0 {
+				tacticCovered[tactic]++
+			}
+		}
+	}
+	for _, tactic := range attackDataset.tactics {
+		coverage.Tactics = append(coverage.Tactics, &tacticCoverageType{
+			ID:         tactic.ID,
+			Name:       tactic.Name,
+			Rules:      tacticRules[tactic.ID],
+			Techniques: tacticTechniques[tactic.Shortname],
+			Covered:    tacticCovered[tactic.Shortname],
+		})
+	}
+	return coverage
+}
+
+func writeAttackCoverageText(out io.Writer, coverage *attackCoverageType) {
+	fmt.Fprintf(out, "%d of %d rules are mapped to ATT&CK\n\n", coverage.Mapped, coverage.Rules)
+	for _, tactic := range coverage.Tactics {
+		fmt.Fprintf(out, "%-7s %-22s rules: %5d  techniques covered: %d/%d\n",
+			tactic.ID, tactic.Name, tactic.Rules, tactic.Covered, tactic.Techniques)
+	}
+	fmt.Fprintln(out)
+	for _, technique := range coverage.Techniques {
+		if technique.Rules > 0 {
+			fmt.Fprintf(out, "%-10s %5d  %s\n", technique.ID, technique.Rules, technique.Name)
+		}
+	}
+}
+
+func writeAttackCoverageJSON(out io.Writer, coverage *attackCoverageType) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(coverage)
+}
+
+type navigatorTechniqueType struct {
+	TechniqueID string `json:"techniqueID"`
+	Score       int    `json:"score"`
+	Comment     string `json:"comment,omitempty"`
+	Enabled     bool   `json:"enabled"`
+}
+
+type navigatorLayerType struct {
+	Name        string                    `json:"name"`
+	Versions    map[string]string         `json:"versions"`
+	Domain      string                    `json:"domain"`
+	Description string                    `json:"description"`
+	Techniques  []*navigatorTechniqueType `json:"techniques"`
+	Gradient    map[string]interface{}    `json:"gradient"`
+}
+
+// writeAttackLayer writes an ATT&CK Navigator layer scoring each technique
+// by the number of rules mapped to it.
+func writeAttackLayer(out io.Writer, coverage *attackCoverageType, name string) error {
+	layer := &navigatorLayerType{
+		Name:        name,
+		Versions:    map[string]string{"layer": "4.5", "navigator": "4.9.1"},
+		Domain:      "enterprise-attack",
+		Description: fmt.Sprintf("Number of YARA rules per technique, %d of %d rules mapped", coverage.Mapped, coverage.Rules),
+		Techniques:  []*navigatorTechniqueType{},
+	}
+	max := 0
+	for _, technique := range coverage.Techniques {
+		if technique.Rules == 0 {
+			continue
+		}
+		layer.Techniques = append(layer.Techniques, &navigatorTechniqueType{
+			TechniqueID: technique.ID,
+			Score:       technique.Rules,
+			Comment:     fmt.Sprintf("rules: %d", technique.Rules),
+			Enabled:     true,
+		})
+		if technique.Rules > max {
+			max = technique.Rules
+		}
+	}
+	layer.Gradient = map[string]interface{}{
+		"colors":   []string{"#ffffff", "#66b1ff"},
+		"minValue": 0,
+		"maxValue": max,
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(layer)
+}
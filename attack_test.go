@@ -0,0 +1,38 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIsAttackMetaKey(t *testing.T) {
+	tests := map[string]bool{
+		"mitre_attack": true,
+		"attack_id":    true,
+		"ttp":          true,
+		"ttps":         true,
+		"tactics":      true,
+		"technique":    true,
+		"http_url":     false,
+		"https":        false,
+		"attachment":   false,
+	}
+	for key, expected := range tests {
+		if isAttackMetaKey(key) != expected {
+			t.Errorf("%s: expected %v", key, expected)
+		}
+	}
+}
+
+func TestExtractAttackWithoutParentTechnique(t *testing.T) {
+	saved := attackDataset
+	defer func() { attackDataset = saved }()
+	attackDataset = newAttackDataset(
+		[]*attackTacticType{{ID: "TA0002", Name: "Execution", Shortname: "execution"}},
+		[]*attackTechniqueType{{ID: "T9999.001", Name: "Custom", Tactics: []string{"execution"}}},
+	)
+	techniques, tactics := extractAttack(&yaraRuleType{Metadata: map[string][]string{"ttp": {"T9999.001"}}})
+	if !reflect.DeepEqual(techniques, []string{"T9999", "T9999.001"}) || !reflect.DeepEqual(tactics, []string{"TA0002"}) {
+		t.Errorf("unexpected techniques %v and tactics %v", techniques, tactics)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+// Bundled ATT&CK Enterprise tactics and techniques, used unless
+// yaraman.attack_file names the enterprise-attack.json STIX bundle
+// published by MITRE. Only common sub-techniques are listed, others are
+// accepted when their technique is known.
+
+// Tactic short names
+const (
+	tacticReconnaissance      = "reconnaissance"
+	tacticResourceDevelopment = "resource-development"
+	tacticInitialAccess       = "initial-access"
+	tacticExecution           = "execution"
+	tacticPersistence         = "persistence"
+	tacticPrivilegeEscalation = "privilege-escalation"
+	tacticDefenseEvasion      = "defense-evasion"
+	tacticCredentialAccess    = "credential-access"
+	tacticDiscovery           = "discovery"
+	tacticLateralMovement     = "lateral-movement"
+	tacticCollection          = "collection"
+	tacticCommandAndControl   = "command-and-control"
+	tacticExfiltration        = "exfiltration"
+	tacticImpact              = "impact"
+)
+
+var builtinAttackTactics = []*attackTacticType{
+	{"TA0043", "Reconnaissance", tacticReconnaissance},
+	{"TA0042", "Resource Development", tacticResourceDevelopment},
+	{"TA0001", "Initial Access", tacticInitialAccess},
+	{"TA0002", "Execution", tacticExecution},
+	{"TA0003", "Persistence", tacticPersistence},
+	{"TA0004", "Privilege Escalation", tacticPrivilegeEscalation},
+	{"TA0005", "Defense Evasion", tacticDefenseEvasion},
+	{"TA0006", "Credential Access", tacticCredentialAccess},
+	{"TA0007", "Discovery", tacticDiscovery},
+	{"TA0008", "Lateral Movement", tacticLateralMovement},
+	{"TA0009", "Collection", tacticCollection},
+	{"TA0011", "Command and Control", tacticCommandAndControl},
+	{"TA0010", "Exfiltration", tacticExfiltration},
+	{"TA0040", "Impact", tacticImpact},
+}
+
+var builtinAttackTechniques = []*attackTechniqueType{
+	// Reconnaissance
+	{"T1595", "Active Scanning", []string{tacticReconnaissance}},
+	{"T1592", "Gather Victim Host Information", []string{tacticReconnaissance}},
+	{"T1589", "Gather Victim Identity Information", []string{tacticReconnaissance}},
+	{"T1590", "Gather Victim Network Information", []string{tacticReconnaissance}},
+	{"T1591", "Gather Victim Org Information", []string{tacticReconnaissance}},
+	{"T1598", "Phishing for Information", []string{tacticReconnaissance}},
+	{"T1597", "Search Closed Sources", []string{tacticReconnaissance}},
+	{"T1596", "Search Open Technical Databases", []string{tacticReconnaissance}},
+	{"T1593", "Search Open Websites/Domains", []string{tacticReconnaissance}},
+	{"T1594", "Search Victim-Owned Websites", []string{tacticReconnaissance}},
+
+	// Resource Development
+	{"T1650", "Acquire Access", []string{tacticResourceDevelopment}},
+	{"T1583", "Acquire Infrastructure", []string{tacticResourceDevelopment}},
+	{"T1586", "Compromise Accounts", []string{tacticResourceDevelopment}},
+	{"T1584", "Compromise Infrastructure", []string{tacticResourceDevelopment}},
+	{"T1587", "Develop Capabilities", []string{tacticResourceDevelopment}},
+	{"T1585", "Establish Accounts", []string{tacticResourceDevelopment}},
+	{"T1588", "Obtain Capabilities", []string{tacticResourceDevelopment}},
+	{"T1608", "Stage Capabilities", []string{tacticResourceDevelopment}},
+
+	// Initial Access
+	{"T1189", "Drive-by Compromise", []string{tacticInitialAccess}},
+	{"T1190", "Exploit Public-Facing Application", []string{tacticInitialAccess}},
+	{"T1133", "External Remote Services", []string{tacticInitialAccess, tacticPersistence}},
+	{"T1200", "Hardware Additions", []string{tacticInitialAccess}},
+	{"T1566", "Phishing", []string{tacticInitialAccess}},
+	{"T1566.001", "Spearphishing Attachment", []string{tacticInitialAccess}},
+	{"T1566.002", "Spearphishing Link", []string{tacticInitialAccess}},
+	{"T1091", "Replication Through Removable Media", []string{tacticInitialAccess, tacticLateralMovement}},
+	{"T1195", "Supply Chain Compromise", []string{tacticInitialAccess}},
+	{"T1199", "Trusted Relationship", []string{tacticInitialAccess}},
+	{"T1078", "Valid Accounts", []string{tacticInitialAccess, tacticPersistence, tacticPrivilegeEscalation, tacticDefenseEvasion}},
+
+	// Execution
+	{"T1651", "Cloud Administration Command", []string{tacticExecution}},
+	{"T1059", "Command and Scripting Interpreter", []string{tacticExecution}},
+	{"T1059.001", "PowerShell", []string{tacticExecution}},
+	{"T1059.003", "Windows Command Shell", []string{tacticExecution}},
+	{"T1059.004", "Unix Shell", []string{tacticExecution}},
+	{"T1059.005", "Visual Basic", []string{tacticExecution}},
+	{"T1059.006", "Python", []string{tacticExecution}},
+	{"T1059.007", "JavaScript", []string{tacticExecution}},
+	{"T1609", "Container Administration Command", []string{tacticExecution}},
+	{"T1610", "Deploy Container", []string{tacticExecution, tacticDefenseEvasion}},
+	{"T1203", "Exploitation for Client Execution", []string{tacticExecution}},
+	{"T1559", "Inter-Process Communication", []string{tacticExecution}},
+	{"T1106", "Native API", []string{tacticExecution}},
+	{"T1053", "Scheduled Task/Job", []string{tacticExecution, tacticPersistence, tacticPrivilegeEscalation}},
+	{"T1053.005", "Scheduled Task", []string{tacticExecution, tacticPersistence, tacticPrivilegeEscalation}},
+	{"T1648", "Serverless Execution", []string{tacticExecution}},
+	{"T1129", "Shared Modules", []string{tacticExecution}},
+	{"T1072", "Software Deployment Tools", []string{tacticExecution, tacticLateralMovement}},
+	{"T1569", "System Services", []string{tacticExecution}},
+	{"T1569.002", "Service Execution", []string{tacticExecution}},
+	{"T1204", "User Execution", []string{tacticExecution}},
+	{"T1204.002", "Malicious File", []string{tacticExecution}},
+	{"T1047", "Windows Management Instrumentation", []string{tacticExecution}},
+
+	// Persistence
+	{"T1098", "Account Manipulation", []string{tacticPersistence, tacticPrivilegeEscalation}},
+	{"T1197", "BITS Jobs", []string{tacticPersistence, tacticDefenseEvasion}},
+	{"T1547", "Boot or Logon Autostart Execution", []string{tacticPersistence, tacticPrivilegeEscalation}},
+	{"T1547.001", "Registry Run Keys / Startup Folder", []string{tacticPersistence, tacticPrivilegeEscalation}},
+	{"T1037", "Boot or Logon Initialization Scripts", []string{tacticPersistence, tacticPrivilegeEscalation}},
+	{"T1176", "Browser Extensions", []string{tacticPersistence}},
+	{"T1554", "Compromise Client Software Binary", []string{tacticPersistence}},
+	{"T1136", "Create Account", []string{tacticPersistence}},
+	{"T1543", "Create or Modify System Process", []string{tacticPersistence, tacticPrivilegeEscalation}},
+	{"T1543.003", "Windows Service", []string{tacticPersistence, tacticPrivilegeEscalation}},
+	{"T1546", "Event Triggered Execution", []string{tacticPersistence, tacticPrivilegeEscalation}},
+	{"T1574", "Hijack Execution Flow", []string{tacticPersistence, tacticPrivilegeEscalation, tacticDefenseEvasion}},
+	{"T1574.001", "DLL Search Order Hijacking", []string{tacticPersistence, tacticPrivilegeEscalation, tacticDefenseEvasion}},
+	{"T1574.002", "DLL Side-Loading", []string{tacticPersistence, tacticPrivilegeEscalation, tacticDefenseEvasion}},
+	{"T1525", "Implant Internal Image", []string{tacticPersistence}},
+	{"T1556", "Modify Authentication Process", []string{tacticCredentialAccess, tacticDefenseEvasion, tacticPersistence}},
+	{"T1137", "Office Application Startup", []string{tacticPersistence}},
+	{"T1542", "Pre-OS Boot", []string{tacticDefenseEvasion, tacticPersistence}},
+	{"T1505", "Server Software Component", []string{tacticPersistence}},
+	{"T1505.003", "Web Shell", []string{tacticPersistence}},
+	{"T1205", "Traffic Signaling", []string{tacticDefenseEvasion, tacticPersistence, tacticCommandAndControl}},
+
+	// Privilege Escalation
+	{"T1548", "Abuse Elevation Control Mechanism", []string{tacticPrivilegeEscalation, tacticDefenseEvasion}},
+	{"T1548.002", "Bypass User Account Control", []string{tacticPrivilegeEscalation, tacticDefenseEvasion}},
+	{"T1134", "Access Token Manipulation", []string{tacticDefenseEvasion, tacticPrivilegeEscalation}},
+	{"T1134.001", "Token Impersonation/Theft", []string{tacticDefenseEvasion, tacticPrivilegeEscalation}},
+	{"T1484", "Domain Policy Modification", []string{tacticDefenseEvasion, tacticPrivilegeEscalation}},
+	{"T1611", "Escape to Host", []string{tacticPrivilegeEscalation}},
+	{"T1068", "Exploitation for Privilege Escalation", []string{tacticPrivilegeEscalation}},
+	{"T1055", "Process Injection", []string{tacticDefenseEvasion, tacticPrivilegeEscalation}},
+	{"T1055.001", "Dynamic-link Library Injection", []string{tacticDefenseEvasion, tacticPrivilegeEscalation}},
+	{"T1055.002", "Portable Executable Injection", []string{tacticDefenseEvasion, tacticPrivilegeEscalation}},
+	{"T1055.012", "Process Hollowing", []string{tacticDefenseEvasion, tacticPrivilegeEscalation}},
+
+	// Defense Evasion
+	{"T1612", "Build Image on Host", []string{tacticDefenseEvasion}},
+	{"T1622", "Debugger Evasion", []string{tacticDefenseEvasion, tacticDiscovery}},
+	{"T1140", "Deobfuscate/Decode Files or Information", []string{tacticDefenseEvasion}},
+	{"T1006", "Direct Volume Access", []string{tacticDefenseEvasion}},
+	{"T1480", "Execution Guardrails", []string{tacticDefenseEvasion}},
+	{"T1211", "Exploitation for Defense Evasion", []string{tacticDefenseEvasion}},
+	{"T1222", "File and Directory Permissions Modification", []string{tacticDefenseEvasion}},
+	{"T1564", "Hide Artifacts", []string{tacticDefenseEvasion}},
+	{"T1562", "Impair Defenses", []string{tacticDefenseEvasion}},
+	{"T1562.001", "Disable or Modify Tools", []string{tacticDefenseEvasion}},
+	{"T1070", "Indicator Removal", []string{tacticDefenseEvasion}},
+	{"T1070.004", "File Deletion", []string{tacticDefenseEvasion}},
+	{"T1202", "Indirect Command Execution", []string{tacticDefenseEvasion}},
+	{"T1036", "Masquerading", []string{tacticDefenseEvasion}},
+	{"T1036.005", "Match Legitimate Name or Location", []string{tacticDefenseEvasion}},
+	{"T1578", "Modify Cloud Compute Infrastructure", []string{tacticDefenseEvasion}},
+	{"T1112", "Modify Registry", []string{tacticDefenseEvasion}},
+	{"T1601", "Modify System Image", []string{tacticDefenseEvasion}},
+	{"T1599", "Network Boundary Bridging", []string{tacticDefenseEvasion}},
+	{"T1027", "Obfuscated Files or Information", []string{tacticDefenseEvasion}},
+	{"T1027.002", "Software Packing", []string{tacticDefenseEvasion}},
+	{"T1027.005", "Indicator Removal from Tools", []string{tacticDefenseEvasion}},
+	{"T1647", "Plist File Modification", []string{tacticDefenseEvasion}},
+	{"T1620", "Reflective Code Loading", []string{tacticDefenseEvasion}},
+	{"T1207", "Rogue Domain Controller", []string{tacticDefenseEvasion}},
+	{"T1014", "Rootkit", []string{tacticDefenseEvasion}},
+	{"T1553", "Subvert Trust Controls", []string{tacticDefenseEvasion}},
+	{"T1553.002", "Code Signing", []string{tacticDefenseEvasion}},
+	{"T1218", "System Binary Proxy Execution", []string{tacticDefenseEvasion}},
+	{"T1218.005", "Mshta", []string{tacticDefenseEvasion}},
+	{"T1218.010", "Regsvr32", []string{tacticDefenseEvasion}},
+	{"T1218.011", "Rundll32", []string{tacticDefenseEvasion}},
+	{"T1216", "System Script Proxy Execution", []string{tacticDefenseEvasion}},
+	{"T1221", "Template Injection", []string{tacticDefenseEvasion}},
+	{"T1127", "Trusted Developer Utilities Proxy Execution", []string{tacticDefenseEvasion}},
+	{"T1535", "Unused/Unsupported Cloud Regions", []string{tacticDefenseEvasion}},
+	{"T1550", "Use Alternate Authentication Material", []string{tacticDefenseEvasion, tacticLateralMovement}},
+	{"T1497", "Virtualization/Sandbox Evasion", []string{tacticDefenseEvasion, tacticDiscovery}},
+	{"T1497.001", "System Checks", []string{tacticDefenseEvasion, tacticDiscovery}},
+	{"T1600", "Weaken Encryption", []string{tacticDefenseEvasion}},
+	{"T1220", "XSL Script Processing", []string{tacticDefenseEvasion}},
+
+	// Credential Access
+	{"T1557", "Adversary-in-the-Middle", []string{tacticCredentialAccess, tacticCollection}},
+	{"T1110", "Brute Force", []string{tacticCredentialAccess}},
+	{"T1555", "Credentials from Password Stores", []string{tacticCredentialAccess}},
+	{"T1555.003", "Credentials from Web Browsers", []string{tacticCredentialAccess}},
+	{"T1212", "Exploitation for Credential Access", []string{tacticCredentialAccess}},
+	{"T1187", "Forced Authentication", []string{tacticCredentialAccess}},
+	{"T1606", "Forge Web Credentials", []string{tacticCredentialAccess}},
+	{"T1056", "Input Capture", []string{tacticCredentialAccess, tacticCollection}},
+	{"T1056.001", "Keylogging", []string{tacticCredentialAccess, tacticCollection}},
+	{"T1111", "Multi-Factor Authentication Interception", []string{tacticCredentialAccess}},
+	{"T1621", "Multi-Factor Authentication Request Generation", []string{tacticCredentialAccess}},
+	{"T1040", "Network Sniffing", []string{tacticCredentialAccess, tacticDiscovery}},
+	{"T1003", "OS Credential Dumping", []string{tacticCredentialAccess}},
+	{"T1003.001", "LSASS Memory", []string{tacticCredentialAccess}},
+	{"T1528", "Steal Application Access Token", []string{tacticCredentialAccess}},
+	{"T1649", "Steal or Forge Authentication Certificates", []string{tacticCredentialAccess}},
+	{"T1558", "Steal or Forge Kerberos Tickets", []string{tacticCredentialAccess}},
+	{"T1539", "Steal Web Session Cookie", []string{tacticCredentialAccess}},
+	{"T1552", "Unsecured Credentials", []string{tacticCredentialAccess}},
+
+	// Discovery
+	{"T1087", "Account Discovery", []string{tacticDiscovery}},
+	{"T1010", "Application Window Discovery", []string{tacticDiscovery}},
+	{"T1217", "Browser Information Discovery", []string{tacticDiscovery}},
+	{"T1580", "Cloud Infrastructure Discovery", []string{tacticDiscovery}},
+	{"T1538", "Cloud Service Dashboard", []string{tacticDiscovery}},
+	{"T1526", "Cloud Service Discovery", []string{tacticDiscovery}},
+	{"T1619", "Cloud Storage Object Discovery", []string{tacticDiscovery}},
+	{"T1613", "Container and Resource Discovery", []string{tacticDiscovery}},
+	{"T1482", "Domain Trust Discovery", []string{tacticDiscovery}},
+	{"T1083", "File and Directory Discovery", []string{tacticDiscovery}},
+	{"T1615", "Group Policy Discovery", []string{tacticDiscovery}},
+	{"T1046", "Network Service Discovery", []string{tacticDiscovery}},
+	{"T1135", "Network Share Discovery", []string{tacticDiscovery}},
+	{"T1201", "Password Policy Discovery", []string{tacticDiscovery}},
+	{"T1120", "Peripheral Device Discovery", []string{tacticDiscovery}},
+	{"T1069", "Permission Groups Discovery", []string{tacticDiscovery}},
+	{"T1057", "Process Discovery", []string{tacticDiscovery}},
+	{"T1012", "Query Registry", []string{tacticDiscovery}},
+	{"T1018", "Remote System Discovery", []string{tacticDiscovery}},
+	{"T1518", "Software Discovery", []string{tacticDiscovery}},
+	{"T1082", "System Information Discovery", []string{tacticDiscovery}},
+	{"T1614", "System Location Discovery", []string{tacticDiscovery}},
+	{"T1016", "System Network Configuration Discovery", []string{tacticDiscovery}},
+	{"T1049", "System Network Connections Discovery", []string{tacticDiscovery}},
+	{"T1033", "System Owner/User Discovery", []string{tacticDiscovery}},
+	{"T1007", "System Service Discovery", []string{tacticDiscovery}},
+	{"T1124", "System Time Discovery", []string{tacticDiscovery}},
+
+	// Lateral Movement
+	{"T1210", "Exploitation of Remote Services", []string{tacticLateralMovement}},
+	{"T1534", "Internal Spearphishing", []string{tacticLateralMovement}},
+	{"T1570", "Lateral Tool Transfer", []string{tacticLateralMovement}},
+	{"T1563", "Remote Service Session Hijacking", []string{tacticLateralMovement}},
+	{"T1021", "Remote Services", []string{tacticLateralMovement}},
+	{"T1021.001", "Remote Desktop Protocol", []string{tacticLateralMovement}},
+	{"T1021.002", "SMB/Windows Admin Shares", []string{tacticLateralMovement}},
+	{"T1080", "Taint Shared Content", []string{tacticLateralMovement}},
+
+	// Collection
+	{"T1560", "Archive Collected Data", []string{tacticCollection}},
+	{"T1560.001", "Archive via Utility", []string{tacticCollection}},
+	{"T1123", "Audio Capture", []string{tacticCollection}},
+	{"T1119", "Automated Collection", []string{tacticCollection}},
+	{"T1185", "Browser Session Hijacking", []string{tacticCollection}},
+	{"T1115", "Clipboard Data", []string{tacticCollection}},
+	{"T1530", "Data from Cloud Storage", []string{tacticCollection}},
+	{"T1602", "Data from Configuration Repository", []string{tacticCollection}},
+	{"T1213", "Data from Information Repositories", []string{tacticCollection}},
+	{"T1005", "Data from Local System", []string{tacticCollection}},
+	{"T1039", "Data from Network Shared Drive", []string{tacticCollection}},
+	{"T1025", "Data from Removable Media", []string{tacticCollection}},
+	{"T1074", "Data Staged", []string{tacticCollection}},
+	{"T1114", "Email Collection", []string{tacticCollection}},
+	{"T1113", "Screen Capture", []string{tacticCollection}},
+	{"T1125", "Video Capture", []string{tacticCollection}},
+
+	// Command and Control
+	{"T1071", "Application Layer Protocol", []string{tacticCommandAndControl}},
+	{"T1071.001", "Web Protocols", []string{tacticCommandAndControl}},
+	{"T1071.004", "DNS", []string{tacticCommandAndControl}},
+	{"T1092", "Communication Through Removable Media", []string{tacticCommandAndControl}},
+	{"T1132", "Data Encoding", []string{tacticCommandAndControl}},
+	{"T1001", "Data Obfuscation", []string{tacticCommandAndControl}},
+	{"T1568", "Dynamic Resolution", []string{tacticCommandAndControl}},
+	{"T1568.002", "Domain Generation Algorithms", []string{tacticCommandAndControl}},
+	{"T1573", "Encrypted Channel", []string{tacticCommandAndControl}},
+	{"T1573.001", "Symmetric Cryptography", []string{tacticCommandAndControl}},
+	{"T1573.002", "Asymmetric Cryptography", []string{tacticCommandAndControl}},
+	{"T1008", "Fallback Channels", []string{tacticCommandAndControl}},
+	{"T1105", "Ingress Tool Transfer", []string{tacticCommandAndControl}},
+	{"T1104", "Multi-Stage Channels", []string{tacticCommandAndControl}},
+	{"T1095", "Non-Application Layer Protocol", []string{tacticCommandAndControl}},
+	{"T1571", "Non-Standard Port", []string{tacticCommandAndControl}},
+	{"T1572", "Protocol Tunneling", []string{tacticCommandAndControl}},
+	{"T1090", "Proxy", []string{tacticCommandAndControl}},
+	{"T1090.003", "Multi-hop Proxy", []string{tacticCommandAndControl}},
+	{"T1219", "Remote Access Software", []string{tacticCommandAndControl}},
+	{"T1102", "Web Service", []string{tacticCommandAndControl}},
+
+	// Exfiltration
+	{"T1020", "Automated Exfiltration", []string{tacticExfiltration}},
+	{"T1030", "Data Transfer Size Limits", []string{tacticExfiltration}},
+	{"T1048", "Exfiltration Over Alternative Protocol", []string{tacticExfiltration}},
+	{"T1041", "Exfiltration Over C2 Channel", []string{tacticExfiltration}},
+	{"T1011", "Exfiltration Over Other Network Medium", []string{tacticExfiltration}},
+	{"T1052", "Exfiltration Over Physical Medium", []string{tacticExfiltration}},
+	{"T1567", "Exfiltration Over Web Service", []string{tacticExfiltration}},
+	{"T1029", "Scheduled Transfer", []string{tacticExfiltration}},
+	{"T1537", "Transfer Data to Cloud Account", []string{tacticExfiltration}},
+
+	// Impact
+	{"T1531", "Account Access Removal", []string{tacticImpact}},
+	{"T1485", "Data Destruction", []string{tacticImpact}},
+	{"T1486", "Data Encrypted for Impact", []string{tacticImpact}},
+	{"T1565", "Data Manipulation", []string{tacticImpact}},
+	{"T1491", "Defacement", []string{tacticImpact}},
+	{"T1561", "Disk Wipe", []string{tacticImpact}},
+	{"T1499", "Endpoint Denial of Service", []string{tacticImpact}},
+	{"T1495", "Firmware Corruption", []string{tacticImpact}},
+	{"T1490", "Inhibit System Recovery", []string{tacticImpact}},
+	{"T1498", "Network Denial of Service", []string{tacticImpact}},
+	{"T1496", "Resource Hijacking", []string{tacticImpact}},
+	{"T1489", "Service Stop", []string{tacticImpact}},
+	{"T1529", "System Shutdown/Reboot", []string{tacticImpact}},
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// parseHexBytes decodes bytes given as hex, ignoring whitespace and
+// separators as in "4d 5a", "4d:5a", "0x4d5a" or "\x4d\x5a".
+func parseHexBytes(s string) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "0x")
+	replacer := strings.NewReplacer(`\x`, "", " ", "", "\t", "", "\n", "", ":", "", ",", "", "-", "")
+	data, err := hex.DecodeString(replacer.Replace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex bytes: %v", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no bytes given")
+	}
+	return data, nil
+}
+
+// ruleStringMatches returns the strings of a rule that match in data,
+// regardless of the rule's condition.
+func ruleStringMatches(doc *yaraRuleType, data *scanDataType) ([]*stringMatchesType, error) {
+	rule, err := parseRuleBody(doc.Body)
+	if err != nil {
+		return nil, err
+	}
+	result := []*stringMatchesType{}
+	for _, s := range rule.Strings {
+		matcher, err := newStringMatcher(s)
+		if err != nil {
+			return nil, err
+		}
+		matches := matcher.find(data)
+		if len(matches) > 0 {
+			result = append(result, &stringMatchesType{Identifier: "$" + s.GetIdentifier(), Matches: matches})
+		}
+	}
+	return result, nil
+}
+
+// searchBytes finds the rules with a string that matches in the given
+// bytes, such as a code fragment from a sample. Modifiers, wildcards and
+// jumps are taken into account as in a scan.
+func searchBytes(docs []*yaraRuleType, bytes []byte) []*ruleMatchType {
+	data := newScanData(bytes)
+	result := []*ruleMatchType{}
+	for _, doc := range docs {
+		matches, err := ruleStringMatches(doc, data)
+		if err != nil {
+			errorLogger.Error().AnErr("error", err).Str("id", doc.ID).Msg("Could not match the strings of the rule.")
+			continue
+		}
+		if len(matches) > 0 {
+			result = append(result, &ruleMatchType{ID: doc.ID, Ruleset: doc.RulesetName, Rule: doc.RuleName, Strings: matches})
+		}
+	}
+	return result
+}
+
+func writeBytesResults(out io.Writer, format string, matches []*ruleMatchType) error {
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		for _, match := range matches {
+			err := encoder.Encode(match)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, match := range matches {
+		fmt.Fprintf(out, "%s  %s  %s\n", match.ID, match.Ruleset, match.Rule)
+		writeStringMatches(out, match.Strings)
+	}
+	return nil
+}
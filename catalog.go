@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default columns of CSV exports
+const defaultColumns = "rule,ruleset,author,creation_date,last_modified,tags"
+
+// docColumn returns the value of a named column for a rule. Names that
+// are not fields of yaraRuleType are looked up in the metadata.
+func docColumn(doc *yaraRuleType, column string) string {
+	switch column {
+	case "id":
+		return doc.ID
+	case "rule":
+		return doc.RuleName
+	case "ruleset":
+		return doc.RulesetName
+	case "tags":
+		return strings.Join(append(append([]string{}, doc.RuleTags...), doc.UserTags...), " ")
+	case "rule_tags":
+		return strings.Join(doc.RuleTags, " ")
+	case "user_tags":
+		return strings.Join(doc.UserTags, " ")
+	case "feed":
+		return doc.Feed
+	case "trust":
+		return doc.Trust
+	case "last_changed":
+		return doc.LastChanged
+	case "min_yara_version":
+		return doc.MinYaraVersion
+	case "fp_hits":
+		if doc.FPHits == nil {
+			return ""
+		}
+		return strconv.Itoa(*doc.FPHits)
+	case "license":
+		return doc.License
+	case "attribution":
+		return ruleAttribution(doc)
+	case "quality":
+		return strconv.Itoa(doc.Quality)
+	case "private":
+		return strconv.FormatBool(doc.Private)
+	case "global":
+		return strconv.FormatBool(doc.Global)
+	}
+	return strings.Join(doc.Metadata[column], "; ")
+}
+
+func parseColumns(columns string) []string {
+	result := []string{}
+	for _, column := range strings.Split(columns, ",") {
+		column = strings.TrimSpace(column)
+		if column != "" {
+			result = append(result, column)
+		}
+	}
+	return result
+}
+
+// csvCell keeps spreadsheets from running a cell as a formula by
+// prefixing values that start like one with a quote.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func writeCSV(out io.Writer, docs []*yaraRuleType, columns []string) error {
+	writer := csv.NewWriter(out)
+	err := writer.Write(columns)
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		row := []string{}
+		for _, column := range columns {
+			row = append(row, csvCell(docColumn(doc, column)))
+		}
+		err = writer.Write(row)
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// A section of a catalog.
+type catalogGroupType struct {
+	Name  string
+	Rules []*yaraRuleType
+}
+
+// groupRules groups rules by ruleset, feed or tag. Rules with several tags
+// are listed under each of them.
+func groupRules(docs []*yaraRuleType, groupBy string) []*catalogGroupType {
+	groups := map[string]*catalogGroupType{}
+	add := func(name string, doc *yaraRuleType) {
+		group, ok := groups[name]
+		if !ok {
+			group = &catalogGroupType{Name: name}
+			groups[name] = group
+		}
+		group.Rules = append(group.Rules, doc)
+	}
+	for _, doc := range docs {
+		switch groupBy {
+		case "tag":
+			tags := append(append([]string{}, doc.RuleTags...), doc.UserTags...)
+			if len(tags) == 0 {
+				add("(untagged)", doc)
+			}
+			for _, tag := range tags {
+				add(tag, doc)
+			}
+		case "feed":
+			if doc.Feed == "" {
+				add("(no feed)", doc)
+			} else {
+				add(doc.Feed, doc)
+			}
+		default:
+			add(doc.RulesetName, doc)
+		}
+	}
+	result := []*catalogGroupType{}
+	for _, group := range groups {
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// Columns of Markdown and HTML catalogs
+var catalogColumns = []string{"rule", "author", "description", "creation_date", "last_modified", "tags"}
+
+var catalogHeaders = []string{"Rule", "Author", "Description", "Created", "Modified", "Tags"}
+
+func markdownCell(s string) string {
+	return strings.NewReplacer("|", `\|`, "<", "&lt;", ">", "&gt;", "\n", " ", "\r", "").Replace(s)
+}
+
+func writeMarkdownCatalog(out io.Writer, docs []*yaraRuleType, groupBy string) {
+	fmt.Fprintf(out, "# YARA rule catalog\n\n%d rules, generated %s.\n", len(docs), time.Now().UTC().Format("2006-01-02"))
+	for _, group := range groupRules(docs, groupBy) {
+		fmt.Fprintf(out, "\n## %s\n\n", markdownCell(group.Name))
+		fmt.Fprintf(out, "| %s |\n", strings.Join(catalogHeaders, " | "))
+		fmt.Fprintf(out, "|%s\n", strings.Repeat(" --- |", len(catalogHeaders)))
+		for _, doc := range group.Rules {
+			cells := []string{}
+			for _, column := range catalogColumns {
+				cells = append(cells, markdownCell(docColumn(doc, column)))
+			}
+			fmt.Fprintf(out, "| %s |\n", strings.Join(cells, " | "))
+		}
+	}
+}
+
+var htmlCatalogTemplate = template.Must(template.New("catalog").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>YARA rule catalog</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #eee; }
+</style>
+</head>
+<body>
+<h1>YARA rule catalog</h1>
+<p>{{.Count}} rules, generated {{.Date}}.</p>
+{{range .Groups}}
+<h2>{{.Name}}</h2>
+<table>
+<tr>{{range $.Headers}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+func writeHTMLCatalog(out io.Writer, docs []*yaraRuleType, groupBy string) error {
+	type htmlGroupType struct {
+		Name string
+		Rows [][]string
+	}
+	groups := []*htmlGroupType{}
+	for _, group := range groupRules(docs, groupBy) {
+		htmlGroup := &htmlGroupType{Name: group.Name}
+		for _, doc := range group.Rules {
+			row := []string{}
+			for _, column := range catalogColumns {
+				row = append(row, docColumn(doc, column))
+			}
+			htmlGroup.Rows = append(htmlGroup.Rows, row)
+		}
+		groups = append(groups, htmlGroup)
+	}
+	return htmlCatalogTemplate.Execute(out, map[string]interface{}{
+		"Count":   len(docs),
+		"Date":    time.Now().UTC().Format("2006-01-02"),
+		"Headers": catalogHeaders,
+		"Groups":  groups,
+	})
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteCSVEscapesFormulas(t *testing.T) {
+	docs := []*yaraRuleType{{
+		RuleName: "test",
+		Metadata: map[string][]string{"description": {"=HYPERLINK(\"http://x\")"}, "author": {"-1+2"}, "note": {"a=b"}},
+	}}
+	var out bytes.Buffer
+	err := writeCSV(&out, docs, []string{"rule", "description", "author", "note"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "rule,description,author,note\ntest,\"'=HYPERLINK(\"\"http://x\"\")\",'-1+2,a=b\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, found %q", expected, out.String())
+	}
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/VirusTotal/gyp/ast"
+	"github.com/blevesearch/bleve"
 	"github.com/rivo/tview"
 )
 
@@ -16,12 +22,25 @@ type ImportCmd struct {
 	Github  string `short:"g" xor:"import" help:"Import YARA rules from a github repository."`
 	File    string `short:"f" xor:"import" help:"Import YARA rules from a file."`
 	URL     string `short:"u" xor:"import" help:"Import YARA rules from a file on the internet."`
+	MISP    string `short:"m" xor:"import" help:"Import the yara attributes of a MISP event JSON file."`
 	Subdirs bool   `short:"s" default:"false" help:"Specify this to process all subdirectories. Only applies to importing from directories."`
+	// Report and MaxErrors control the import report
+	Report    string `short:"r" default:"text" enum:"none,text,json" help:"Format of the import report written to stdout (none, text or json)."`
+	MaxErrors int    `default:"-1" help:"Exit with a non-zero status if more than this many files fail to import. A negative value disables the check."`
+	NoHistory bool   `help:"Do not index rule versions from the git history of the imported rules."`
+}
+
+// HistoryCmd holds CLI values for showing the import history.
+type HistoryCmd struct {
+	ID     int    `arg:"" optional:"" help:"Number of the import to show in detail."`
+	Rule   string `short:"r" help:"ID of a rule whose version history is to be shown."`
+	Format string `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
 }
 
 // ValuesCmd holds CLI values for listing values of a searchable field.
 type ValuesCmd struct {
-	Field string `required:"true" short:"f" help:"Name of field whose values are to be listed."`
+	Field    string `required:"true" short:"f" help:"Name of field whose values are to be listed."`
+	Unmapped bool   `help:"For the actor and malware_family fields, list the names in the metadata of rules that are not in the alias dictionary."`
 }
 
 // FieldsCmd is an empty struct to use for creating a Run method
@@ -35,11 +54,26 @@ type ListCmd struct {
 
 // ExportCmd holds CLI values for exporting YARA rules.
 type ExportCmd struct {
-	Format string `short:"f" default:"yara" enum:"json,yara" help:"Format of the exported data (yara or json)."`
+	Format     string `short:"f" default:"yara" enum:"yara,json,jsonl,csv,markdown,html,stix,misp" help:"Format of the exported data (yara, json, jsonl, csv, markdown, html, stix or misp). json and jsonl write one rule per line."`
+	Columns    string `default:"rule,ruleset,author,creation_date,last_modified,tags,license,attribution" help:"Comma separated columns of CSV exports. Names other than id, rule, ruleset, tags, rule_tags, user_tags, feed, trust, last_changed, min_yara_version, fp_hits, quality, license, attribution, private and global are read from the metadata."`
+	GroupBy    string `default:"ruleset" enum:"ruleset,tag,feed" help:"Grouping of rules in Markdown and HTML catalogs (ruleset, tag or feed)."`
+	EventInfo  string `default:"YARA rules exported by yaraman" help:"Info of the event in MISP exports."`
+	Query      string `short:"q" help:"Query selecting the rules to export, in bleve query string syntax."`
+	MaxFPHits  int    `default:"-1" help:"Only export rules that matched at most this many goodware files in the last fptest run. A negative value disables the check."`
+	MinQuality int    `placeholder:"SCORE" help:"Only export rules with a quality score of at least this, from 0 to 100."`
+	Sort       string `default:"ruleset" enum:"ruleset,quality,last_changed" help:"Order of the exported rules (ruleset, quality or last_changed). quality and last_changed put the best and the most recently changed rules first."`
+	// Rules that need a newer YARA version are listed on stderr
+	YaraVersion string `placeholder:"VERSION" help:"Only export rules supported by this YARA version, like 3.11."`
 }
 
 // SearchCmd holds CLI values for searching for YARA rules.
 type SearchCmd struct {
+	Query         string `arg:"" optional:"" help:"Query in bleve query string syntax. All rules are returned if no query is given."`
+	ChangedWithin int    `placeholder:"DAYS" help:"Only return rules changed in the last number of days."`
+	Strings       string `short:"s" placeholder:"QUERY" help:"Only return rules with a string definition matching this query. Fields are identifier, type, value, modifiers and hex."`
+	Bytes         string `short:"b" placeholder:"HEX" help:"Only return rules with a string that matches in these bytes, given as hex. Matching strings and offsets are shown."`
+	Format        string `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
+	Sort          string `default:"ruleset" enum:"ruleset,quality,last_changed" help:"Order of the results (ruleset, quality or last_changed). quality and last_changed put the best and the most recently changed rules first."`
 }
 
 // InteractiveCmd is the placeholder for interactive mode
@@ -50,6 +84,145 @@ type InteractiveCmd struct {
 type VersionCmd struct {
 }
 
+// RollbackCmd holds CLI values for undoing imports.
+type RollbackCmd struct {
+	ID int `arg:"" help:"Number of the import to roll back. Later imports are rolled back as well."`
+}
+
+// DiffCmd holds CLI values for comparing rules.
+type DiffCmd struct {
+	From   string `arg:"" help:"Rule ID (ID@N for version N from the git history), ruleset file or import number to compare from."`
+	To     string `arg:"" help:"Rule ID, ruleset file or import number to compare to."`
+	Type   string `short:"t" default:"auto" enum:"auto,rule,file,import" help:"Type of the compared items (auto, rule, file or import)."`
+	Format string `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
+}
+
+// SyncCmd holds CLI values for syncing the feeds defined in the configuration.
+type SyncCmd struct {
+	Feeds     []string      `arg:"" optional:"" help:"Names of the feeds to sync. All feeds are synced if none are given."`
+	Daemon    bool          `short:"D" help:"Keep running and sync the feeds on an interval."`
+	Interval  time.Duration `short:"i" help:"Interval between syncs in daemon mode (default is sync_interval from the configuration, or 1h)."`
+	NoHistory bool          `help:"Do not index rule versions from the git history of the feeds."`
+}
+
+// ScanCmd holds CLI values for scanning files with indexed rules.
+type ScanCmd struct {
+	Query   string   `short:"q" help:"Query selecting the rules to scan with, in bleve query string syntax. All rules are used if no query is given."`
+	Paths   []string `arg:"" help:"Files or directories to scan."`
+	Subdirs bool     `short:"s" help:"Scan files in subdirectories of the given directories."`
+	Format  string   `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
+}
+
+// FPTestCmd holds CLI values for testing rules against goodware.
+type FPTestCmd struct {
+	Query   string `short:"q" help:"Query selecting the rules to test, in bleve query string syntax. All rules are tested if no query is given."`
+	Corpus  string `required:"true" help:"Directory of goodware files."`
+	Subdirs bool   `short:"s" help:"Also scan files in subdirectories of the corpus."`
+	Format  string `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
+}
+
+// TestCmd holds CLI values for testing rules against their samples.
+type TestCmd struct {
+	Query      string `short:"q" help:"Query selecting the rules to test, in bleve query string syntax. All rules with samples are tested if no query is given."`
+	SamplesDir string `help:"Directory searched for samples given by hash (default is samples_dir from the configuration)."`
+	JUnit      string `name:"junit" placeholder:"FILE" help:"Write a JUnit XML report to the file, - for stdout."`
+	Format     string `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
+}
+
+// SamplesAddCmd holds CLI values for associating samples with a rule.
+type SamplesAddCmd struct {
+	ID      string   `arg:"" help:"ID of the rule."`
+	Samples []string `arg:"" help:"Sample files or their MD5, SHA1 or SHA256 hashes."`
+}
+
+// SamplesRemoveCmd holds CLI values for removing samples from a rule.
+type SamplesRemoveCmd struct {
+	ID      string   `arg:"" help:"ID of the rule."`
+	Samples []string `arg:"" help:"Sample files or hashes to remove."`
+}
+
+// SamplesListCmd holds CLI values for listing the samples of a rule.
+type SamplesListCmd struct {
+	ID string `arg:"" help:"ID of the rule."`
+}
+
+// SamplesCmd holds CLI values for managing the samples of rules
+type SamplesCmd struct {
+	Add    SamplesAddCmd    `cmd:"" help:"Add samples a rule is expected to match."`
+	Remove SamplesRemoveCmd `cmd:"" help:"Remove samples added to a rule."`
+	List   SamplesListCmd   `cmd:"" help:"List the samples of a rule, including hashes from its metadata."`
+}
+
+// IOCsCmd holds CLI values for listing the indicators of rules.
+type IOCsCmd struct {
+	Query  string `short:"q" help:"Query selecting the rules, in bleve query string syntax. All rules are used if no query is given."`
+	Format string `short:"f" default:"text" enum:"text,json,csv" help:"Output format (text, json or csv)."`
+}
+
+// GraphCmd holds CLI values for showing rule dependencies.
+type GraphCmd struct {
+	Rule   string `short:"r" help:"ID or name of a rule whose dependencies and dependents are shown. All rules with dependencies are shown if not given."`
+	Format string `short:"f" default:"dot" enum:"dot,json" help:"Output format (dot or json)."`
+	Check  bool   `help:"Only list references to rules that are not in any indexed ruleset, failing if there are any."`
+}
+
+// AttackCoverageCmd holds CLI values for the ATT&CK coverage report.
+type AttackCoverageCmd struct {
+	Query  string `short:"q" help:"Query selecting the rules, in bleve query string syntax. All rules are used if no query is given."`
+	Format string `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
+}
+
+// AttackLayerCmd holds CLI values for writing an ATT&CK Navigator layer.
+type AttackLayerCmd struct {
+	Query string `short:"q" help:"Query selecting the rules, in bleve query string syntax. All rules are used if no query is given."`
+	Name  string `default:"YARA rule coverage" help:"Name of the layer."`
+}
+
+// AttackCmd holds CLI values for reporting the ATT&CK mapping of rules.
+type AttackCmd struct {
+	Coverage AttackCoverageCmd `cmd:"" help:"Show the number of rules per ATT&CK tactic and technique."`
+	Layer    AttackLayerCmd    `cmd:"" help:"Write an ATT&CK Navigator layer scoring techniques by their number of rules."`
+}
+
+// MappingTestCmd holds CLI values for resolving a metadata key.
+type MappingTestCmd struct {
+	Key   string `arg:"" help:"Metadata key."`
+	Value string `help:"Metadata value to transform."`
+}
+
+// MappingCmd holds CLI values for inspecting the metadata mapping.
+type MappingCmd struct {
+	Test MappingTestCmd `cmd:"" help:"Show how a metadata key is normalized and which mappings match it."`
+}
+
+// DatesCmd holds CLI values for listing the metadata dates of rules.
+type DatesCmd struct {
+	Query     string `short:"q" help:"Query selecting the rules, in bleve query string syntax. All rules are used if no query is given."`
+	Unparsed  bool   `xor:"confidence" help:"Only list dates that could not be parsed."`
+	Ambiguous bool   `xor:"confidence" help:"Only list dates whose day and month may have been swapped."`
+	Format    string `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
+}
+
+// StatsDatesCmd holds CLI values for the monthly histogram of a date field.
+type StatsDatesCmd struct {
+	Query  string `short:"q" help:"Query selecting the rules, in bleve query string syntax. All rules are used if no query is given."`
+	Field  string `default:"creation_date" enum:"creation_date,last_modified,release_date,imported,last_changed" help:"Date field to count rules by (creation_date, last_modified, release_date, imported or last_changed)."`
+	Format string `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
+}
+
+// StatsSummaryCmd holds CLI values for the summary of the indexed rules.
+type StatsSummaryCmd struct {
+	Query  string `short:"q" help:"Query selecting the rules, in bleve query string syntax. All rules are used if no query is given."`
+	Top    int    `default:"10" help:"Number of rulesets, feeds, authors and tags to list. All are listed if 0."`
+	Format string `short:"f" default:"text" enum:"text,json" help:"Output format (text or json)."`
+}
+
+// StatsCmd holds CLI values for statistics about the indexed rules.
+type StatsCmd struct {
+	Summary StatsSummaryCmd `cmd:"" default:"1" help:"Summarize the indexed rules. This is the default."`
+	Dates   StatsDatesCmd   `cmd:"" help:"Show the number of rules per month of a date field."`
+}
+
 // CLI is the master structure for all CLI commands.
 var CLI struct {
 	ConfigFile  string         `short:"c" default:"${config_file}"`
@@ -59,7 +232,21 @@ var CLI struct {
 	Import      ImportCmd      `cmd:"" help:"Import YARA rules."`
 	List        ListCmd        `cmd:"" help:"List searchable fields or values of a field."`
 	Export      ExportCmd      `cmd:"" help:"Export YARA rules that match the specified criteria, or all rules if no criteria are specified."`
-	Search      ExportCmd      `cmd:"" help:"Search YARA rules using the specified query criteria."`
+	Search      SearchCmd      `cmd:"" help:"Search YARA rules using the specified query criteria."`
+	History     HistoryCmd     `cmd:"" help:"Show the history of imports."`
+	Rollback    RollbackCmd    `cmd:"" help:"Restore the index to its state before an import."`
+	Sync        SyncCmd        `cmd:"" help:"Update the feeds defined in the configuration and import their rules."`
+	Diff        DiffCmd        `cmd:"" help:"Show the semantic differences between two rules, ruleset files or imports."`
+	Scan        ScanCmd        `cmd:"" help:"Scan files with the rules matching a query."`
+	FPTest      FPTestCmd      `cmd:"" name:"fptest" help:"Count the goodware files each rule matches and store the count in the fp_hits field."`
+	Samples     SamplesCmd     `cmd:"" help:"Manage the samples rules are expected to match."`
+	Test        TestCmd        `cmd:"" help:"Check that rules still match their samples."`
+	IOCs        IOCsCmd        `cmd:"" name:"iocs" help:"List the indicators found in the strings and metadata of rules."`
+	Graph       GraphCmd       `cmd:"" help:"Show the dependency graph of rules referring to other rules."`
+	Attack      AttackCmd      `cmd:"" help:"Report the MITRE ATT&CK techniques and tactics rules are mapped to."`
+	Mapping     MappingCmd     `cmd:"" help:"Inspect the mapping of metadata keys to normalized fields."`
+	Dates       DatesCmd       `cmd:"" help:"List the dates in the metadata of rules with their normalized value and confidence."`
+	Stats       StatsCmd       `cmd:"" help:"Show statistics about the indexed rules."`
 	Interactive InteractiveCmd `cmd:"" help:"Enter interactive mode."`
 }
 
@@ -74,7 +261,17 @@ func yaraFileFunc(ctx *YaramanContext, filename string) error {
 	if !found {
 		return nil
 	}
-	return parseRulesetFile(ctx, filename, makeRulesetDoc, makeRuleDoc)
+	rules, err := parseRulesetFile(ctx, filename, makeRulesetDoc, makeRuleDoc)
+	// Errors are recorded in the import report so that the remaining
+	// files are still imported.
+	if ctx.report != nil {
+		ctx.report.addFile(filename, rules, err)
+		if err != nil {
+			return nil
+		}
+		return ctx.report.finishRuleset(ctx, filename)
+	}
+	return err
 }
 
 // Run executes the VersionCmd.
@@ -83,8 +280,71 @@ func (cmd *VersionCmd) Run(ctx *YaramanContext) error {
 	return nil
 }
 
+// source returns the type and location of the rules being imported.
+func (cmd *ImportCmd) source() (string, string) {
+	switch {
+	case cmd.Dir != "":
+		return "dir", cmd.Dir
+	case cmd.File != "":
+		return "file", cmd.File
+	case cmd.URL != "":
+		return "url", cmd.URL
+	case cmd.Github != "":
+		return "github", cmd.Github
+	case cmd.MISP != "":
+		return "misp", cmd.MISP
+	}
+	return "", ""
+}
+
+// commit returns the git commit of the rules being imported if they are
+// in a git working tree.
+func (cmd *ImportCmd) commit() string {
+	switch {
+	case cmd.Dir != "":
+		return gitHeadCommit(cmd.Dir)
+	case cmd.File != "":
+		return gitHeadCommit(filepath.Dir(cmd.File))
+	}
+	return ""
+}
+
 // Run executes the ImportCmd to import YARA rules from various sources.
 func (cmd *ImportCmd) Run(ctx *YaramanContext) error {
+	sourceType, source := cmd.source()
+	ctx.report = newImportReport(sourceType, source)
+	ctx.report.Commit = cmd.commit()
+	clearDirectoryLicenses()
+	err := cmd.importRules(ctx)
+	if err != nil {
+		return abortImport(ctx, err)
+	}
+	return cmd.finishReport(ctx)
+}
+
+// finishReport stores the import report, writes it to stdout and checks
+// the error threshold.
+func (cmd *ImportCmd) finishReport(ctx *YaramanContext) error {
+	report, err := finishImport(ctx, !cmd.NoHistory)
+	if err != nil {
+		return err
+	}
+
+	switch cmd.Report {
+	case "text":
+		report.writeText(os.Stdout)
+	case "json":
+		report.writeJSON(os.Stdout)
+	}
+	logger.Info().Int("import", report.ID).Int("files", len(report.Files)).Int("rules", report.RuleCount).Int("errors", report.ErrorCount).Msg("Import finished")
+
+	if cmd.MaxErrors >= 0 && report.ErrorCount > cmd.MaxErrors {
+		return fmt.Errorf("%d files failed to import, more than the maximum of %d", report.ErrorCount, cmd.MaxErrors)
+	}
+	return nil
+}
+
+func (cmd *ImportCmd) importRules(ctx *YaramanContext) error {
 	switch {
 	case cmd.Dir != "":
 		logger.Info().Msg("Importing directory")
@@ -105,33 +365,314 @@ func (cmd *ImportCmd) Run(ctx *YaramanContext) error {
 		}
 
 	case cmd.URL != "":
-		resp, err := http.Get(cmd.URL)
+		// Each URL has its own file, and so its own ruleset, in the rules
+		// directory
+		path, err := urlLocalPath(ctx, cmd.URL)
+		if err != nil {
+			return err
+		}
+		err = downloadFile(cmd.URL, path)
 		if err != nil {
 			errorLogger.Error().AnErr("error", err).Str("url", cmd.URL).Msg("error reading YARA from web")
 			return nil
 		}
-		data, err := ioutil.ReadAll(resp.Body)
+		err = yaraFileFunc(ctx, path)
 		if err != nil {
-			errorLogger.Error().AnErr("error", err).Str("url", cmd.URL).Msg("error reading data from web page")
+			errorLogger.Error().AnErr("error", err).Str("filename", path).Msg("error parsing yara file")
 			return nil
 		}
-		err = ioutil.WriteFile("test.yara", data, 0644)
+
+	case cmd.Github != "":
+		logger.Info().Str("repository", cmd.Github).Msg("Import from github")
+
+	case cmd.MISP != "":
+		logger.Info().Str("filename", cmd.MISP).Msg("Importing MISP event")
+		return importMISPEvent(ctx, cmd.MISP)
+	}
+	return nil
+}
+
+// Run executes the HistoryCmd to show previous imports.
+func (cmd *HistoryCmd) Run(ctx *YaramanContext) error {
+	if cmd.Rule != "" {
+		return cmd.showRule(ctx)
+	}
+	if cmd.ID > 0 {
+		report, err := loadImportReport(ctx, cmd.ID)
 		if err != nil {
-			errorLogger.Error().AnErr("error", err).Str("url", cmd.URL).Msg("error writing web page to disk")
-			return nil
+			return err
+		}
+		if cmd.Format == "json" {
+			return report.writeJSON(os.Stdout)
 		}
-		err = yaraFileFunc(ctx, "test.yara")
+		report.writeText(os.Stdout)
+		return nil
+	}
+
+	reports, err := loadImportReports(ctx)
+	if err != nil {
+		return err
+	}
+	if cmd.Format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(reports)
+	}
+	for _, report := range reports {
+		report.writeSummary(os.Stdout)
+	}
+	return nil
+}
+
+func (cmd *HistoryCmd) showRule(ctx *YaramanContext) error {
+	doc, err := getRuleDoc(ctx, cmd.Rule)
+	if err != nil {
+		return err
+	}
+	if doc == nil {
+		return fmt.Errorf("rule %s not found", cmd.Rule)
+	}
+	versions, err := loadRuleVersions(ctx, cmd.Rule)
+	if err != nil {
+		return err
+	}
+	if cmd.Format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(versions)
+	}
+	writeRuleVersions(os.Stdout, doc, versions)
+	return nil
+}
+
+// Run executes the SearchCmd to list matching rules.
+func (cmd *SearchCmd) Run(ctx *YaramanContext) error {
+	q := buildQuery(cmd.Query)
+	if cmd.ChangedWithin > 0 {
+		q = bleve.NewConjunctionQuery(q, changedWithinQuery(cmd.ChangedWithin))
+	}
+	if cmd.Strings != "" {
+		stringsQ, err := stringsQuery(ctx, cmd.Strings)
 		if err != nil {
-			errorLogger.Error().AnErr("error", err).Str("filename", "test.yara").Msg("error parsing yara file")
-			return nil
+			return err
+		}
+		q = bleve.NewConjunctionQuery(q, stringsQ)
+	}
+	var data []byte
+	if cmd.Bytes != "" {
+		var err error
+		data, err = parseHexBytes(cmd.Bytes)
+		if err != nil {
+			return err
+		}
+	}
+	docs, err := searchRules(ctx, q)
+	if err != nil {
+		return err
+	}
+	sortRules(docs, cmd.Sort)
+	if data != nil {
+		return writeBytesResults(os.Stdout, cmd.Format, searchBytes(docs, data))
+	}
+	return writeSearchResults(os.Stdout, cmd.Format, docs)
+}
+
+// diffType determines what is being compared when the type is auto.
+func (cmd *DiffCmd) diffType() string {
+	if cmd.Type != "auto" {
+		return cmd.Type
+	}
+	if fileExists(cmd.From) && fileExists(cmd.To) {
+		return "file"
+	}
+	_, err1 := strconv.Atoi(cmd.From)
+	_, err2 := strconv.Atoi(cmd.To)
+	if err1 == nil && err2 == nil {
+		return "import"
+	}
+	return "rule"
+}
+
+// loadRule loads an indexed rule, or one of its versions if the
+// reference has the form ID@N.
+func loadRule(ctx *YaramanContext, ref string) (string, *ast.Rule, error) {
+	parts := strings.SplitN(ref, "@", 2)
+	doc, err := getRuleDoc(ctx, parts[0])
+	if err != nil {
+		return "", nil, err
+	}
+	if doc == nil {
+		return "", nil, fmt.Errorf("rule %s not found", parts[0])
+	}
+	name := doc.RuleName
+	body := doc.Body
+	if len(parts) == 2 {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid version %s", parts[1])
 		}
+		versions, err := loadRuleVersions(ctx, doc.ID)
+		if err != nil {
+			return "", nil, err
+		}
+		if n < 1 || n > len(versions) {
+			return "", nil, fmt.Errorf("rule %s has %d versions", doc.ID, len(versions))
+		}
+		name = fmt.Sprintf("%s@%d", doc.RuleName, n)
+		body = versions[n-1].Body
+	}
+	rule, err := parseRuleBody(body)
+	return name, rule, err
+}
 
-	case cmd.Github != "":
-		logger.Info().Str("repository", cmd.Github).Msg("Import from github")
+func (cmd *DiffCmd) diff(ctx *YaramanContext) (*rulesDiffType, error) {
+	switch cmd.diffType() {
+	case "file":
+		from, err := parseRulesFile(cmd.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parseRulesFile(cmd.To)
+		if err != nil {
+			return nil, err
+		}
+		return diffRuleSets(cmd.From, from, cmd.To, to), nil
+
+	case "import":
+		rules := []map[string]*ast.Rule{}
+		for _, arg := range []string{cmd.From, cmd.To} {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid import number %s", arg)
+			}
+			snapshot, err := importSnapshot(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			parsed, err := parseRuleDocs(snapshot)
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, parsed)
+		}
+		return diffRuleSets("import "+cmd.From, rules[0], "import "+cmd.To, rules[1]), nil
+	}
+
+	fromName, from, err := loadRule(ctx, cmd.From)
+	if err != nil {
+		return nil, err
+	}
+	toName, to, err := loadRule(ctx, cmd.To)
+	if err != nil {
+		return nil, err
+	}
+	result := diffRuleSets(fromName, map[string]*ast.Rule{}, toName, map[string]*ast.Rule{})
+	if ruleDiff := diffRules(toName, from, to); ruleDiff != nil {
+		result.Changed = append(result.Changed, ruleDiff)
+	}
+	return result, nil
+}
+
+// Run executes the DiffCmd to compare rules.
+func (cmd *DiffCmd) Run(ctx *YaramanContext) error {
+	result, err := cmd.diff(ctx)
+	if err != nil {
+		return err
+	}
+	if cmd.Format == "json" {
+		return result.writeJSON(os.Stdout)
 	}
+	result.writeText(os.Stdout)
 	return nil
 }
 
+// selectedFeeds returns the feeds named on the command line, or all
+// feeds if none were named.
+func (cmd *SyncCmd) selectedFeeds(ctx *YaramanContext) ([]*feedType, error) {
+	if len(cmd.Feeds) == 0 {
+		return ctx.feeds, nil
+	}
+	byName := map[string]*feedType{}
+	for _, feed := range ctx.feeds {
+		byName[feed.Name] = feed
+	}
+	feeds := []*feedType{}
+	for _, name := range cmd.Feeds {
+		feed, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("feed %s is not defined in %s", name, ctx.configFile)
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, nil
+}
+
+// syncFeeds syncs every feed, continuing with the next feed on errors.
+func (cmd *SyncCmd) syncFeeds(ctx *YaramanContext, feeds []*feedType) int {
+	failed := 0
+	for _, feed := range feeds {
+		report, err := syncFeed(ctx, feed, !cmd.NoHistory)
+		if err != nil {
+			failed++
+			errorLogger.Error().AnErr("error", err).Str("feed", feed.Name).Msg("Could not sync feed.")
+			fmt.Printf("%s: %v\n", feed.Name, err)
+			continue
+		}
+		fmt.Printf("%s: ", feed.Name)
+		report.writeSummary(os.Stdout)
+	}
+	return failed
+}
+
+// Run executes the SyncCmd to update feeds, once or on an interval.
+func (cmd *SyncCmd) Run(ctx *YaramanContext) error {
+	feeds, err := cmd.selectedFeeds(ctx)
+	if err != nil {
+		return err
+	}
+	if len(feeds) == 0 {
+		return fmt.Errorf("no feeds are defined in %s", ctx.configFile)
+	}
+	if !cmd.Daemon {
+		if failed := cmd.syncFeeds(ctx, feeds); failed > 0 {
+			return fmt.Errorf("%d of %d feeds failed to sync", failed, len(feeds))
+		}
+		return nil
+	}
+
+	interval := cmd.Interval
+	if interval == 0 {
+		interval = ctx.syncInterval
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	logger.Info().Str("interval", interval.String()).Msg("Sync daemon started")
+	for {
+		cmd.syncFeeds(ctx, feeds)
+		select {
+		case <-ticker.C:
+		case <-signals:
+			logger.Info().Msg("Sync daemon stopped")
+			return nil
+		}
+	}
+}
+
+// Run executes the RollbackCmd to undo imports.
+func (cmd *RollbackCmd) Run(ctx *YaramanContext) error {
+	reports, err := rollbackImports(ctx, cmd.ID)
+	for _, report := range reports {
+		fmt.Printf("Rolled back import %d: %d added rules removed, %d changed and %d removed rules restored\n",
+			report.ID, len(report.Added), len(report.Changed), len(report.Removed))
+	}
+	if err == nil && len(reports) == 0 {
+		fmt.Printf("Nothing to roll back, import %d and later imports are already rolled back\n", cmd.ID)
+	}
+	return err
+}
+
 // Run starts yaraman in interactive mode.
 func (cmd *InteractiveCmd) Run(ctx *YaramanContext) error {
 	box := tview.NewBox().SetBorder(true).SetTitle("Hello, world!")
@@ -140,3 +681,376 @@ func (cmd *InteractiveCmd) Run(ctx *YaramanContext) error {
 	}
 	return nil
 }
+
+// Run executes the ScanCmd to report the rules matching each file.
+func (cmd *ScanCmd) Run(ctx *YaramanContext) error {
+	docs, err := searchRules(ctx, buildQuery(cmd.Query))
+	if err != nil {
+		return err
+	}
+	scanner, err := newScanner(ctx, docs)
+	if err != nil {
+		return err
+	}
+	logger.Info().Int("rules", len(docs)).Msg("Scanning")
+
+	results := []*scanResultType{}
+	err = scanner.scanPaths(ctx, cmd.Paths, cmd.Subdirs, func(result *scanResultType) error {
+		if cmd.Format == "json" {
+			results = append(results, result)
+		} else {
+			writeScanResultText(os.Stdout, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if cmd.Format == "json" {
+		return writeScanJSON(os.Stdout, results, scanner.unsupportedRules())
+	}
+	writeUnsupportedText(os.Stdout, scanner.unsupportedRules())
+	return nil
+}
+
+// Run executes the FPTestCmd to record false positive hits of rules.
+func (cmd *FPTestCmd) Run(ctx *YaramanContext) error {
+	if !dirExists(cmd.Corpus) {
+		return fmt.Errorf("directory %s does not exist", cmd.Corpus)
+	}
+	docs, err := searchRules(ctx, buildQuery(cmd.Query))
+	if err != nil {
+		return err
+	}
+	results, unsupportedRules, err := runFPTest(ctx, docs, cmd.Corpus, cmd.Subdirs)
+	if err != nil {
+		return err
+	}
+	return writeFPResults(os.Stdout, cmd.Format, results, unsupportedRules)
+}
+
+// Run executes the ExportCmd to write the selected rules.
+func (cmd *ExportCmd) Run(ctx *YaramanContext) error {
+	q := buildQuery(cmd.Query)
+	if cmd.MaxFPHits >= 0 {
+		q = bleve.NewConjunctionQuery(q, maxFPHitsQuery(cmd.MaxFPHits))
+	}
+	if cmd.MinQuality > 0 {
+		q = bleve.NewConjunctionQuery(q, minQualityQuery(cmd.MinQuality))
+	}
+	docs, err := searchRules(ctx, q)
+	if err != nil {
+		return err
+	}
+	sortRules(docs, cmd.Sort)
+	if cmd.YaraVersion != "" {
+		version, err := parseYaraVersion(cmd.YaraVersion)
+		if err != nil {
+			return err
+		}
+		docs, err = filterYaraVersion(docs, version, os.Stderr)
+		if err != nil {
+			return err
+		}
+	}
+	deps := map[string][]*yaraRuleType{}
+	if containsString(sourceFormats, cmd.Format) {
+		deps, err = ruleDependencies(ctx, docs)
+		if err != nil {
+			return err
+		}
+	}
+	// The rules referred to are exported whatever the query
+	exported := withDependencies(docs, deps)
+	if cmd.YaraVersion != "" && len(exported) > len(docs) {
+		version, _ := parseYaraVersion(cmd.YaraVersion)
+		compatible, err := filterYaraVersion(exported, version, os.Stderr)
+		if err != nil {
+			return err
+		}
+		if len(compatible) < len(exported) {
+			return fmt.Errorf("exported rules refer to rules YARA %s does not support", version)
+		}
+	}
+	// Violations are listed on stderr
+	err = checkLicensePolicy(exported, licensePolicy, os.Stderr)
+	if err != nil {
+		return err
+	}
+	return writeExport(os.Stdout, cmd.Format, docs, &exportOptionsType{
+		Columns:      parseColumns(cmd.Columns),
+		GroupBy:      cmd.GroupBy,
+		EventInfo:    cmd.EventInfo,
+		Dependencies: deps,
+	})
+}
+
+func ruleDocForID(ctx *YaramanContext, id string) (*yaraRuleType, error) {
+	doc, err := getRuleDoc(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("rule %s not found", id)
+	}
+	return doc, nil
+}
+
+// Run executes the SamplesAddCmd.
+func (cmd *SamplesAddCmd) Run(ctx *YaramanContext) error {
+	_, err := ruleDocForID(ctx, cmd.ID)
+	if err != nil {
+		return err
+	}
+	return addRuleSamples(ctx, cmd.ID, cmd.Samples, false)
+}
+
+// Run executes the SamplesRemoveCmd.
+func (cmd *SamplesRemoveCmd) Run(ctx *YaramanContext) error {
+	return addRuleSamples(ctx, cmd.ID, cmd.Samples, true)
+}
+
+// Run executes the SamplesListCmd.
+func (cmd *SamplesListCmd) Run(ctx *YaramanContext) error {
+	doc, err := ruleDocForID(ctx, cmd.ID)
+	if err != nil {
+		return err
+	}
+	samples, err := ruleSamples(ctx, doc)
+	if err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		fmt.Println(sample)
+	}
+	return nil
+}
+
+// Run executes the TestCmd. It fails if any rule does not match one of
+// its samples.
+func (cmd *TestCmd) Run(ctx *YaramanContext) error {
+	docs, err := searchRules(ctx, buildQuery(cmd.Query))
+	if err != nil {
+		return err
+	}
+	samplesDir := cmd.SamplesDir
+	if samplesDir == "" {
+		samplesDir = ctx.samplesDir
+	}
+	tests, err := runSampleTests(ctx, docs, newSampleStore(samplesDir))
+	if err != nil {
+		return err
+	}
+
+	if cmd.JUnit == "-" {
+		err = writeJUnit(os.Stdout, tests)
+	} else {
+		if cmd.Format == "json" {
+			err = json.NewEncoder(os.Stdout).Encode(tests)
+		} else {
+			writeSampleTestsText(os.Stdout, tests)
+		}
+		if err == nil && cmd.JUnit != "" {
+			err = writeJUnitFile(cmd.JUnit, tests)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	failed := countTests(tests, testFailed) + countTests(tests, testError)
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sample tests failed", failed, len(tests))
+	}
+	return nil
+}
+
+// Run executes the IOCsCmd to list the indicators of each rule.
+func (cmd *IOCsCmd) Run(ctx *YaramanContext) error {
+	docs, err := searchRules(ctx, buildQuery(cmd.Query))
+	if err != nil {
+		return err
+	}
+	return writeIOCs(os.Stdout, cmd.Format, docs)
+}
+
+// Run executes the GraphCmd to write the dependency graph or check it
+// for dangling references.
+func (cmd *GraphCmd) Run(ctx *YaramanContext) error {
+	docs, err := searchRules(ctx, buildQuery(""))
+	if err != nil {
+		return err
+	}
+	graph := buildRuleGraph(docs)
+	if cmd.Check {
+		writeDanglingRefs(os.Stdout, graph.dangling)
+		if len(graph.dangling) > 0 {
+			return fmt.Errorf("%d references to missing rules", len(graph.dangling))
+		}
+		return nil
+	}
+
+	var ids []string
+	if cmd.Rule != "" {
+		ids = graph.findRules(cmd.Rule)
+		if len(ids) == 0 {
+			return fmt.Errorf("rule %s not found", cmd.Rule)
+		}
+	}
+	graph = graph.subgraph(ids)
+	if cmd.Format == "json" {
+		return writeGraphJSON(os.Stdout, graph)
+	}
+	writeGraphDOT(os.Stdout, graph)
+	return nil
+}
+
+// Run executes the AttackCoverageCmd.
+func (cmd *AttackCoverageCmd) Run(ctx *YaramanContext) error {
+	docs, err := searchRules(ctx, buildQuery(cmd.Query))
+	if err != nil {
+		return err
+	}
+	coverage := attackCoverage(docs)
+	if cmd.Format == "json" {
+		return writeAttackCoverageJSON(os.Stdout, coverage)
+	}
+	writeAttackCoverageText(os.Stdout, coverage)
+	return nil
+}
+
+// Run executes the AttackLayerCmd.
+func (cmd *AttackLayerCmd) Run(ctx *YaramanContext) error {
+	docs, err := searchRules(ctx, buildQuery(cmd.Query))
+	if err != nil {
+		return err
+	}
+	return writeAttackLayer(os.Stdout, attackCoverage(docs), cmd.Name)
+}
+
+// Run executes the ValuesCmd to list the indexed values of a field and
+// the number of documents with each.
+func (cmd *ValuesCmd) Run(ctx *YaramanContext) error {
+	if cmd.Unmapped {
+		if cmd.Field != aliasActor && cmd.Field != aliasFamily {
+			return fmt.Errorf("--unmapped only applies to the %s and %s fields", aliasActor, aliasFamily)
+		}
+		docs, err := searchRules(ctx, buildQuery(""))
+		if err != nil {
+			return err
+		}
+		for _, alias := range unmappedAliases(docs, cmd.Field) {
+			fmt.Printf("%6d  %s\n", alias.Rules, alias.Name)
+		}
+		return nil
+	}
+
+	dict, err := ctx.index.FieldDict(cmd.Field)
+	if err != nil {
+		return err
+	}
+	defer dict.Close()
+	for {
+		entry, err := dict.Next()
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return nil
+		}
+		fmt.Printf("%6d  %s\n", entry.Count, entry.Term)
+	}
+}
+
+// Run executes the MappingTestCmd.
+func (cmd *MappingTestCmd) Run(ctx *YaramanContext) error {
+	field, mapping := resolveMetaKey(cmd.Key)
+	fmt.Printf("Key:   %s\n", strings.ToLower(cmd.Key))
+	fmt.Printf("Field: %s\n", field)
+	if mapping == nil {
+		fmt.Println("No mapping matches, the key is kept.")
+	} else {
+		fmt.Printf("Mapping: %s\n", mapping)
+		if len(mapping.Transforms) > 0 {
+			fmt.Printf("Transforms: %s\n", strings.Join(mapping.Transforms, ", "))
+		}
+	}
+	if dateFields.Contains(field) {
+		fmt.Println("Values are normalized as dates.")
+	}
+	if cmd.Value != "" {
+		values, dates := transformMetaValue(field, mapping, cmd.Value, ctx.dateOrder)
+		fmt.Printf("Values: %q\n", values)
+		for _, date := range dates {
+			fmt.Printf("Date confidence: %s\n", date.Confidence)
+		}
+	}
+	matching := matchingMappings(strings.ToLower(cmd.Key))
+	if len(matching) > 1 {
+		fmt.Println("Also matching:")
+		for _, other := range matching[1:] {
+			fmt.Printf("  %s -> %s\n", other, other.Field)
+		}
+	}
+	return nil
+}
+
+// Run executes the DatesCmd.
+func (cmd *DatesCmd) Run(ctx *YaramanContext) error {
+	confidence := ""
+	switch {
+	case cmd.Unparsed:
+		confidence = dateUnparsed
+	case cmd.Ambiguous:
+		confidence = dateAmbiguous
+	}
+	q := buildQuery(cmd.Query)
+	if confidence != "" {
+		confidenceQuery := bleve.NewTermQuery(confidence)
+		confidenceQuery.SetField("dates.confidence")
+		q = bleve.NewConjunctionQuery(q, confidenceQuery)
+	}
+	docs, err := searchRules(ctx, q)
+	if err != nil {
+		return err
+	}
+	dates := ruleDates(docs, confidence)
+	if cmd.Format == "json" {
+		return writeDatesJSON(os.Stdout, dates)
+	}
+	writeDatesText(os.Stdout, dates)
+	return nil
+}
+
+// Run executes the StatsDatesCmd.
+func (cmd *StatsDatesCmd) Run(ctx *YaramanContext) error {
+	months, err := dateHistogram(ctx, buildQuery(cmd.Query), cmd.Field)
+	if err != nil {
+		return err
+	}
+	if cmd.Format == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(months)
+	}
+	writeDateHistogram(os.Stdout, months)
+	return nil
+}
+
+// Run executes the StatsSummaryCmd.
+func (cmd *StatsSummaryCmd) Run(ctx *YaramanContext) error {
+	docs, err := searchRules(ctx, buildQuery(cmd.Query))
+	if err != nil {
+		return err
+	}
+	reports, err := loadImportReports(ctx)
+	if err != nil {
+		return err
+	}
+	stats := corpusStats(docs, failedRulesets(reports), cmd.Top)
+	if cmd.Format == "json" {
+		return writeCorpusStatsJSON(os.Stdout, stats)
+	}
+	writeCorpusStatsText(os.Stdout, stats)
+	return nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/VirusTotal/gyp/ast"
+)
+
+// Condition features indexed in the condition_features field
+const (
+	featureFilesize     = "filesize"
+	featureEntrypoint   = "entrypoint"
+	featureAt           = "at"
+	featureIn           = "in"
+	featureOf           = "of"
+	featureFor          = "for"
+	featureThem         = "them"
+	featureMatches      = "matches"
+	featureContains     = "contains"
+	featureStringCount  = "string_count"
+	featureStringOffset = "string_offset"
+	featureStringLength = "string_length"
+)
+
+// What a rule condition uses, as found by analyzeCondition.
+type conditionInfoType struct {
+	// Modules whose fields or functions are used, like pe
+	Modules []string
+	// Functions called, like pe.imphash or uint16
+	Functions []string
+	// Module fields read, like pe.number_of_sections. Subscripts are left
+	// out, so pe.sections[0].name is pe.sections.name.
+	ModuleFields []string
+	// Names of other rules the condition refers to
+	RuleRefs []string
+	Features []string
+}
+
+// dottedName returns the name of a member access chain, like
+// pe.sections.name for pe.sections[0].name.
+func dottedName(expression ast.Expression) string {
+	switch e := expression.(type) {
+	case *ast.Identifier:
+		return e.Identifier
+	case *ast.MemberAccess:
+		container := dottedName(e.Container)
+		if container == "" {
+			return ""
+		}
+		return container + "." + e.Member
+	case *ast.Subscripting:
+		return dottedName(e.Array)
+	}
+	return ""
+}
+
+// subscriptIndexes returns the index expressions of a member access
+// chain, which may refer to rules or loop variables.
+func subscriptIndexes(expression ast.Expression) []ast.Node {
+	nodes := []ast.Node{}
+	for {
+		switch e := expression.(type) {
+		case *ast.MemberAccess:
+			expression = e.Container
+		case *ast.Subscripting:
+			nodes = append(nodes, e.Index)
+			expression = e.Array
+		default:
+			return nodes
+		}
+	}
+}
+
+func sortedKeys(set MapSet) []string {
+	keys := []string{}
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// analyzeCondition walks the condition of a rule and records the modules,
+// functions, rules and language features it uses.
+func analyzeCondition(rule *ast.Rule) *conditionInfoType {
+	modules, functions, fields, refs, features := MapSet{}, MapSet{}, MapSet{}, MapSet{}, MapSet{}
+	// Variables of the enclosing for loops
+	variables := MapSet{}
+
+	// Member access chains are recorded as a whole, only their subscripts
+	// and function arguments are walked further.
+	var walk func(node ast.Node)
+	walk = func(node ast.Node) {
+		if node == nil {
+			return
+		}
+		children := childNodes(node)
+		switch v := node.(type) {
+		case ast.Keyword:
+			switch v {
+			case ast.KeywordFilesize:
+				features.Add(featureFilesize)
+			case ast.KeywordEntrypoint:
+				features.Add(featureEntrypoint)
+			case ast.KeywordThem:
+				features.Add(featureThem)
+			}
+		case *ast.Identifier:
+			if !variables.Contains(v.Identifier) {
+				refs.Add(v.Identifier)
+			}
+		case *ast.MemberAccess, *ast.Subscripting:
+			name := dottedName(v.(ast.Expression))
+			if name != "" && !variables.Contains(rootIdentifier(v.(ast.Expression))) {
+				fields.Add(name)
+				modules.Add(rootIdentifier(v.(ast.Expression)))
+			}
+			children = subscriptIndexes(v.(ast.Expression))
+		case *ast.FunctionCall:
+			name := dottedName(v.Callable)
+			if name != "" && !variables.Contains(rootIdentifier(v.Callable)) {
+				functions.Add(name)
+				if _, ok := v.Callable.(*ast.Identifier); !ok {
+					modules.Add(rootIdentifier(v.Callable))
+				}
+			}
+			children = subscriptIndexes(v.Callable)
+			for _, argument := range v.Arguments {
+				children = append(children, argument)
+			}
+		case *ast.StringIdentifier:
+			if v.At != nil {
+				features.Add(featureAt)
+			}
+			if v.In != nil {
+				features.Add(featureIn)
+			}
+		case *ast.StringCount:
+			features.Add(featureStringCount)
+		case *ast.StringOffset:
+			features.Add(featureStringOffset)
+		case *ast.StringLength:
+			features.Add(featureStringLength)
+		case *ast.Of:
+			features.Add(featureOf)
+		case *ast.ForOf:
+			features.Add(featureFor)
+		case *ast.ForIn:
+			features.Add(featureFor)
+			walk(v.Quantifier)
+			walk(v.Iterator)
+			// The variables are only bound in the loop body
+			bound := []string{}
+			for _, variable := range v.Variables {
+				if !variables.Contains(variable) {
+					variables.Add(variable)
+					bound = append(bound, variable)
+				}
+			}
+			walk(v.Condition)
+			for _, variable := range bound {
+				variables.Remove(variable)
+			}
+			children = nil
+		case *ast.Operation:
+			switch v.Operator {
+			case ast.OpMatches:
+				features.Add(featureMatches)
+			case ast.OpContains:
+				features.Add(featureContains)
+			}
+		}
+		for _, child := range children {
+			walk(child)
+		}
+	}
+	walk(rule.Condition)
+
+	return &conditionInfoType{
+		Modules:      sortedKeys(modules),
+		Functions:    sortedKeys(functions),
+		ModuleFields: sortedKeys(fields),
+		RuleRefs:     sortedKeys(refs),
+		Features:     sortedKeys(features),
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAnalyzeConditionLoopVariables(t *testing.T) {
+	rule, err := parseRuleBody(`import "pe"
+rule LoopRule {
+  condition:
+    for any section in pe.sections : (section.name == ".text" and section.raw_data_size > 0) and
+    for any i in (0..pe.number_of_sections - 1) : (pe.sections[i].name == ".data") and
+    i
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := analyzeCondition(rule)
+	if !reflect.DeepEqual(info.Modules, []string{"pe"}) {
+		t.Errorf("expected modules [pe], found %v", info.Modules)
+	}
+	expected := []string{"pe.number_of_sections", "pe.sections", "pe.sections.name"}
+	if !reflect.DeepEqual(info.ModuleFields, expected) {
+		t.Errorf("expected fields %v, found %v", expected, info.ModuleFields)
+	}
+	// i is only a loop variable inside its loop
+	if !reflect.DeepEqual(info.RuleRefs, []string{"i"}) {
+		t.Errorf("expected rule refs [i], found %v", info.RuleRefs)
+	}
+}
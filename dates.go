@@ -1,11 +1,17 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/araddon/dateparse"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
 )
 
 var (
@@ -137,15 +143,305 @@ func normalizeDateInternal(fromDate string) string {
 	return testDate
 }
 
+// Confidence in a normalized date
+const (
+	// A full date that can only be read one way
+	dateHigh = "high"
+	// Day and month could be swapped and no date order was configured
+	dateAmbiguous = "ambiguous"
+	// Only the year, quarter, month or week is known, the date is the
+	// first day of it
+	dateApproximate = "approximate"
+	dateUnparsed    = "unparsed"
+)
+
+// Orders of day and month in dates like 03/04/2019, configured by
+// date_order for all rules or per feed
+const (
+	dateOrderUS = "us"
+	dateOrderEU = "eu"
+)
+
+// Date from the metadata of a rule, as written and normalized.
+type ruleDateType struct {
+	Field      string `json:"field"`
+	Raw        string `json:"raw"`
+	Date       string `json:"date,omitempty"`
+	Confidence string `json:"confidence"`
+}
+
+var (
+	isoWeekRE     = regexp.MustCompile(`^(\d{4})-?[Ww](\d{1,2})(?:-?([1-7]))?$`)
+	quarterRE     = regexp.MustCompile(`^[Qq]([1-4])[ /-]?(\d{4})$`)
+	yearQuarterRE = regexp.MustCompile(`^(\d{4})[ /-]?[Qq]([1-4])$`)
+	unixTimeRE    = regexp.MustCompile(`^(\d{9,10}|\d{13})$`)
+	yearRE        = regexp.MustCompile(`^\d{4}$`)
+	sixDigitsRE   = regexp.MustCompile(`^\d{6}$`)
+	lettersRE     = regexp.MustCompile(`[A-Za-z]`)
+
+	// Timestamps before this are not taken as dates of rules
+	firstYaraRelease = time.Date(2008, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func validateDateOrder(order string) error {
+	switch order {
+	case "", dateOrderUS, dateOrderEU:
+		return nil
+	}
+	return fmt.Errorf("unknown date order %q, expected us or eu", order)
+}
+
+// parseSpecialDate parses ISO weeks like 2019-W12, quarters like Q3 2018
+// and Unix timestamps in seconds or milliseconds. Any long enough number
+// looks like a timestamp, so they are approximate at best and only taken
+// between the first YARA release and now. Other numbers of their length
+// are left unparsed.
+func parseSpecialDate(value string) (time.Time, string, bool) {
+	if match := isoWeekRE.FindStringSubmatch(value); match != nil {
+		year, _ := strconv.Atoi(match[1])
+		week, _ := strconv.Atoi(match[2])
+		if week < 1 || week > 53 {
+			return time.Time{}, "", false
+		}
+		// Week 1 is the week with January 4th, weeks start on Monday
+		january4 := time.Date(year, 1, 4, 0, 0, 0, 0, time.UTC)
+		monday := january4.AddDate(0, 0, -((int(january4.Weekday())+6)%7)+(week-1)*7)
+		if match[3] == "" {
+			return monday, dateApproximate, true
+		}
+		day, _ := strconv.Atoi(match[3])
+		return monday.AddDate(0, 0, day-1), dateHigh, true
+	}
+	quarter, year := "", ""
+	if match := quarterRE.FindStringSubmatch(value); match != nil {
+		quarter, year = match[1], match[2]
+	} else if match := yearQuarterRE.FindStringSubmatch(value); match != nil {
+		quarter, year = match[2], match[1]
+	}
+	if quarter != "" {
+		q, _ := strconv.Atoi(quarter)
+		y, _ := strconv.Atoi(year)
+		return time.Date(y, time.Month((q-1)*3+1), 1, 0, 0, 0, 0, time.UTC), dateApproximate, true
+	}
+	if unixTimeRE.MatchString(value) {
+		n, _ := strconv.ParseInt(value, 10, 64)
+		date := time.Unix(n, 0).UTC()
+		if len(value) == 13 {
+			date = time.Unix(0, n*int64(time.Millisecond)).UTC()
+		}
+		if date.Before(firstYaraRelease) || date.After(time.Now()) {
+			return time.Time{}, dateUnparsed, true
+		}
+		return date, dateApproximate, true
+	}
+	return time.Time{}, "", false
+}
+
+// parseDate normalizes a date from rule metadata and rates how sure the
+// result is. Dates like 03/04/2019 are read in the given order, or as
+// month first and ambiguous without one.
+func parseDate(raw string, order string) *ruleDateType {
+	result := &ruleDateType{Raw: raw, Confidence: dateUnparsed}
+	value := strings.TrimSpace(raw)
+	if value == "" {
+		return result
+	}
+	if date, confidence, ok := parseSpecialDate(value); ok {
+		if confidence == dateUnparsed {
+			return result
+		}
+		result.Date = date.Format("2006-01-02")
+		result.Confidence = confidence
+		return result
+	}
+
+	normalized := normalizeDateInternal(value)
+	confidence := dateHigh
+	parts := strings.Split(normalized, "/")
+	switch {
+	case len(parts) == 3 && !lettersRE.MatchString(value):
+		// normalizeDateInternal already put a day over 12 second
+		month, err1 := strconv.Atoi(parts[0])
+		day, err2 := strconv.Atoi(parts[1])
+		_, err3 := strconv.Atoi(parts[2])
+		if err1 == nil && err2 == nil && err3 == nil && len(parts[0]) <= 2 && month <= 12 && day <= 12 && month != day {
+			switch order {
+			case dateOrderEU:
+				normalized = parts[1] + "/" + parts[0] + "/" + parts[2]
+			case "":
+				confidence = dateAmbiguous
+			}
+		}
+	case len(parts) == 2 || yearRE.MatchString(normalized):
+		confidence = dateApproximate
+	}
+	// Six digits could be YYMMDD, DDMMYY or MMDDYY
+	if sixDigitsRE.MatchString(value) {
+		confidence = dateAmbiguous
+	}
+
+	date, err := dateparse.ParseLocal(normalized)
+	if err != nil {
+		date, err = dateparse.ParseLocal(value)
+		if err != nil {
+			return result
+		}
+		confidence = dateHigh
+	}
+	result.Date = date.Format("2006-01-02")
+	result.Confidence = confidence
+	return result
+}
+
 // Dates in YARA rules are in a deplorable state. Normalize as best we can for searching.
 func normalizeDate(dateString string) string {
-	normalizedDateString := normalizeDateInternal(dateString)
-	if normalizedDateString == "" {
-		return ""
+	return parseDate(dateString, "").Date
+}
+
+// Date of a rule listed by the dates command.
+type dateReportType struct {
+	ID      string `json:"id"`
+	Ruleset string `json:"ruleset"`
+	Rule    string `json:"rule"`
+	*ruleDateType
+}
+
+// ruleDates lists the metadata dates of rules, only those with the given
+// confidence if it is not empty.
+func ruleDates(docs []*yaraRuleType, confidence string) []*dateReportType {
+	dates := []*dateReportType{}
+	for _, doc := range docs {
+		for _, date := range doc.Dates {
+			if confidence == "" || date.Confidence == confidence {
+				dates = append(dates, &dateReportType{doc.ID, doc.RulesetName, doc.RuleName, date})
+			}
+		}
+	}
+	return dates
+}
+
+func writeDatesText(out io.Writer, dates []*dateReportType) {
+	for _, date := range dates {
+		normalized := date.Date
+		if normalized == "" {
+			normalized = "-"
+		}
+		fmt.Fprintf(out, "%s  %s:%s  %s  %-11s  %-10s  %q\n",
+			date.ID, date.Ruleset, date.Rule, date.Field, date.Confidence, normalized, date.Raw)
+	}
+}
+
+func writeDatesJSON(out io.Writer, dates []*dateReportType) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(dates)
+}
+
+// Fields of yaraRuleType indexed as dates, which can be searched with
+// ranges like creation_date:>2020-01-01 or last_modified:>now-90d
+var rangeDateFields = []string{"creation_date", "last_modified", "release_date", "imported", "last_changed"}
+
+// setRuleDates sets the date fields of a rule to the first date of each
+// field that could be parsed.
+func setRuleDates(doc *yaraRuleType) {
+	fields := map[string]*string{
+		"creation_date": &doc.CreationDate,
+		"last_modified": &doc.LastModified,
+		"release_date":  &doc.ReleaseDate,
+	}
+	for _, date := range doc.Dates {
+		if field, ok := fields[date.Field]; ok && *field == "" && date.Date != "" {
+			*field = date.Date
+		}
+	}
+}
+
+// Number of rules with a date in a month.
+type monthCountType struct {
+	Month string `json:"month"`
+	Rules int    `json:"rules"`
+}
+
+// dateBoundary returns the earliest or latest date of a field in the
+// rules matching q, the zero time if no rule has the field.
+func dateBoundary(ctx *YaramanContext, q query.Query, field string, latest bool) (time.Time, error) {
+	request := bleve.NewSearchRequestOptions(ruleDocsQuery(q), 1, 0, false)
+	request.Fields = []string{field}
+	if latest {
+		request.SortBy([]string{"-" + field})
+	} else {
+		request.SortBy([]string{field})
+	}
+	result, err := ctx.index.Search(request)
+	if err != nil || len(result.Hits) == 0 {
+		return time.Time{}, err
+	}
+	value, _ := result.Hits[0].Fields[field].(string)
+	return time.Parse(time.RFC3339, value)
+}
+
+// dateHistogram counts the rules matching q by the month of a date field,
+// from the first month with a rule to the last.
+func dateHistogram(ctx *YaramanContext, q query.Query, field string) ([]*monthCountType, error) {
+	hasDate := bleve.NewDateRangeQuery(time.Unix(0, 0).UTC(), time.Time{})
+	hasDate.SetField(field)
+	q = bleve.NewConjunctionQuery(q, hasDate)
+	first, err := dateBoundary(ctx, q, field, false)
+	if err != nil {
+		return nil, err
+	}
+	last, err := dateBoundary(ctx, q, field, true)
+	if err != nil {
+		return nil, err
+	}
+	months := []*monthCountType{}
+	if first.IsZero() {
+		return months, nil
+	}
+
+	start := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.UTC)
+	starts := []time.Time{}
+	for month := start; !month.After(last); month = month.AddDate(0, 1, 0) {
+		starts = append(starts, month)
+	}
+	facet := bleve.NewFacetRequest(field, len(starts))
+	for _, month := range starts {
+		end := month.AddDate(0, 1, 0)
+		facet.AddDateTimeRange(month.Format("2006-01"), month, end)
 	}
-	normalizedDate, err := dateparse.ParseLocal(normalizedDateString)
+	request := bleve.NewSearchRequestOptions(ruleDocsQuery(q), 0, 0, false)
+	request.AddFacet(field, facet)
+	result, err := ctx.index.Search(request)
 	if err != nil {
-		return ""
+		return nil, err
+	}
+	counts := map[string]int{}
+	for _, dateRange := range result.Facets[field].DateRanges {
+		counts[dateRange.Name] = dateRange.Count
+	}
+	for _, month := range starts {
+		name := month.Format("2006-01")
+		months = append(months, &monthCountType{name, counts[name]})
+	}
+	return months, nil
+}
+
+// writeDateHistogram prints one line per month with a bar scaled to the
+// busiest month.
+func writeDateHistogram(out io.Writer, months []*monthCountType) {
+	const width = 50
+	most := 0
+	for _, month := range months {
+		if month.Rules > most {
+			most = month.Rules
+		}
+	}
+	for _, month := range months {
+		bar := 0
+		if most > 0 {
+			bar = (month.Rules*width + most - 1) / most
+		}
+		line := fmt.Sprintf("%s  %6d  %s", month.Month, month.Rules, strings.Repeat("#", bar))
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
 	}
-	return normalizedDate.Format("2006-01-02")
 }
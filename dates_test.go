@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		raw        string
+		date       string
+		confidence string
+	}{
+		{"2019-03-04", "2019-03-04", dateHigh},
+		{"2019-W12", "2019-03-18", dateApproximate},
+		{"Q3 2018", "2018-07-01", dateApproximate},
+		{"1555555555", "2019-04-18", dateApproximate},
+		{"1555555555000", "2019-04-18", dateApproximate},
+		{"123456789", "", dateUnparsed},
+		{"9999999999", "", dateUnparsed},
+	}
+	for _, test := range tests {
+		result := parseDate(test.raw, "")
+		if result.Date != test.date || result.Confidence != test.confidence {
+			t.Errorf("%s: expected %s (%s), found %s (%s)", test.raw, test.date, test.confidence, result.Date, result.Confidence)
+		}
+	}
+}
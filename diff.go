@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/VirusTotal/gyp/ast"
+)
+
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// Change to a string definition, matched by identifier.
+type stringChangeType struct {
+	Identifier       string   `json:"identifier"`
+	Change           string   `json:"change"`
+	From             string   `json:"from,omitempty"`
+	To               string   `json:"to,omitempty"`
+	AddedModifiers   []string `json:"added_modifiers,omitempty"`
+	RemovedModifiers []string `json:"removed_modifiers,omitempty"`
+}
+
+// Change to the values of a metadata key.
+type metaChangeType struct {
+	Key    string   `json:"key"`
+	Change string   `json:"change"`
+	From   []string `json:"from,omitempty"`
+	To     []string `json:"to,omitempty"`
+}
+
+// Semantic differences between two versions of a rule.
+type ruleDiffType struct {
+	Rule             string              `json:"rule"`
+	Change           string              `json:"change"`
+	AddedModifiers   []string            `json:"added_modifiers,omitempty"`
+	RemovedModifiers []string            `json:"removed_modifiers,omitempty"`
+	AddedTags        []string            `json:"added_tags,omitempty"`
+	RemovedTags      []string            `json:"removed_tags,omitempty"`
+	Meta             []*metaChangeType   `json:"meta,omitempty"`
+	Strings          []*stringChangeType `json:"strings,omitempty"`
+	ConditionFrom    string              `json:"condition_from,omitempty"`
+	ConditionTo      string              `json:"condition_to,omitempty"`
+}
+
+// Semantic differences between two sets of rules.
+type rulesDiffType struct {
+	From    string          `json:"from"`
+	To      string          `json:"to"`
+	Added   []string        `json:"added"`
+	Removed []string        `json:"removed"`
+	Changed []*ruleDiffType `json:"changed"`
+}
+
+// listChanges returns the entries only in to and the entries only in from.
+func listChanges(from []string, to []string) ([]string, []string) {
+	fromSet := MapSet{}
+	fromSet.AddFromSlice(from)
+	toSet := MapSet{}
+	toSet.AddFromSlice(to)
+	added := []string{}
+	for _, v := range to {
+		if !fromSet.Contains(v) {
+			added = append(added, v)
+		}
+	}
+	removed := []string{}
+	for _, v := range from {
+		if !toSet.Contains(v) {
+			removed = append(removed, v)
+		}
+	}
+	return added, removed
+}
+
+func ruleModifiers(rule *ast.Rule) []string {
+	modifiers := []string{}
+	if rule.Global {
+		modifiers = append(modifiers, "global")
+	}
+	if rule.Private {
+		modifiers = append(modifiers, "private")
+	}
+	return modifiers
+}
+
+// ruleStrings returns the string definitions of a rule by identifier.
+// Anonymous strings are keyed by their position among anonymous strings.
+func ruleStrings(rule *ast.Rule) ([]string, map[string]*stringDefType) {
+	keys := []string{}
+	defs := map[string]*stringDefType{}
+	anonymous := 0
+	for _, s := range rule.Strings {
+		def := describeString(s)
+		key := "$" + def.Identifier
+		if def.Identifier == "" {
+			anonymous++
+			key = fmt.Sprintf("$ (anonymous %d)", anonymous)
+		}
+		keys = append(keys, key)
+		defs[key] = def
+	}
+	return keys, defs
+}
+
+// ruleMeta returns the metadata values of a rule by key, keeping the
+// keys in their original order.
+func ruleMeta(rule *ast.Rule) ([]string, map[string][]string) {
+	keys := []string{}
+	values := map[string][]string{}
+	for _, meta := range rule.Meta {
+		if _, ok := values[meta.Key]; !ok {
+			keys = append(keys, meta.Key)
+		}
+		values[meta.Key] = append(values[meta.Key], fmt.Sprintf("%#v", meta.Value))
+	}
+	return keys, values
+}
+
+func conditionSource(rule *ast.Rule) string {
+	var builder strings.Builder
+	rule.Condition.WriteSource(&builder)
+	return builder.String()
+}
+
+func diffStrings(from *ast.Rule, to *ast.Rule) []*stringChangeType {
+	changes := []*stringChangeType{}
+	fromKeys, fromDefs := ruleStrings(from)
+	toKeys, toDefs := ruleStrings(to)
+	for _, key := range fromKeys {
+		fromDef := fromDefs[key]
+		toDef, ok := toDefs[key]
+		if !ok {
+			changes = append(changes, &stringChangeType{Identifier: key, Change: changeRemoved, From: fromDef.source()})
+			continue
+		}
+		if fromDef.source() == toDef.source() {
+			continue
+		}
+		change := &stringChangeType{Identifier: key, Change: changeChanged}
+		if fromDef.Type != toDef.Type || fromDef.Value != toDef.Value {
+			change.From = fromDef.source()
+			change.To = toDef.source()
+		}
+		added, removed := listChanges(fromDef.Modifiers, toDef.Modifiers)
+		if len(added) > 0 {
+			change.AddedModifiers = added
+		}
+		if len(removed) > 0 {
+			change.RemovedModifiers = removed
+		}
+		changes = append(changes, change)
+	}
+	for _, key := range toKeys {
+		if _, ok := fromDefs[key]; !ok {
+			changes = append(changes, &stringChangeType{Identifier: key, Change: changeAdded, To: toDefs[key].source()})
+		}
+	}
+	return changes
+}
+
+func diffMeta(from *ast.Rule, to *ast.Rule) []*metaChangeType {
+	changes := []*metaChangeType{}
+	fromKeys, fromValues := ruleMeta(from)
+	toKeys, toValues := ruleMeta(to)
+	for _, key := range fromKeys {
+		toValue, ok := toValues[key]
+		switch {
+		case !ok:
+			changes = append(changes, &metaChangeType{Key: key, Change: changeRemoved, From: fromValues[key]})
+		case strings.Join(fromValues[key], "\n") != strings.Join(toValue, "\n"):
+			changes = append(changes, &metaChangeType{Key: key, Change: changeChanged, From: fromValues[key], To: toValue})
+		}
+	}
+	for _, key := range toKeys {
+		if _, ok := fromValues[key]; !ok {
+			changes = append(changes, &metaChangeType{Key: key, Change: changeAdded, To: toValues[key]})
+		}
+	}
+	return changes
+}
+
+// diffRules compares two versions of a rule. It returns nil if they are
+// semantically identical.
+func diffRules(name string, from *ast.Rule, to *ast.Rule) *ruleDiffType {
+	result := &ruleDiffType{Rule: name, Change: changeChanged}
+	changed := false
+
+	added, removed := listChanges(ruleModifiers(from), ruleModifiers(to))
+	if len(added) > 0 || len(removed) > 0 {
+		result.AddedModifiers, result.RemovedModifiers = added, removed
+		changed = true
+	}
+	added, removed = listChanges(from.Tags, to.Tags)
+	if len(added) > 0 || len(removed) > 0 {
+		result.AddedTags, result.RemovedTags = added, removed
+		changed = true
+	}
+	if meta := diffMeta(from, to); len(meta) > 0 {
+		result.Meta = meta
+		changed = true
+	}
+	if strs := diffStrings(from, to); len(strs) > 0 {
+		result.Strings = strs
+		changed = true
+	}
+	fromCondition, toCondition := conditionSource(from), conditionSource(to)
+	if fromCondition != toCondition {
+		result.ConditionFrom, result.ConditionTo = fromCondition, toCondition
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return result
+}
+
+// diffRuleSets compares two sets of rules keyed by name.
+func diffRuleSets(fromName string, from map[string]*ast.Rule, toName string, to map[string]*ast.Rule) *rulesDiffType {
+	result := &rulesDiffType{
+		From:    fromName,
+		To:      toName,
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []*ruleDiffType{},
+	}
+	for name, fromRule := range from {
+		toRule, ok := to[name]
+		if !ok {
+			result.Removed = append(result.Removed, name)
+			continue
+		}
+		if ruleDiff := diffRules(name, fromRule, toRule); ruleDiff != nil {
+			result.Changed = append(result.Changed, ruleDiff)
+		}
+	}
+	for name := range to {
+		if _, ok := from[name]; !ok {
+			result.Added = append(result.Added, name)
+		}
+	}
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+	sort.Slice(result.Changed, func(i, j int) bool {
+		return result.Changed[i].Rule < result.Changed[j].Rule
+	})
+	return result
+}
+
+// parseRuleBody parses the body of a single rule.
+func parseRuleBody(body string) (*ast.Rule, error) {
+	ruleset, err := parseYara(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(ruleset.Rules) != 1 {
+		return nil, fmt.Errorf("expected 1 rule, found %d", len(ruleset.Rules))
+	}
+	return ruleset.Rules[0], nil
+}
+
+// parseRuleDocs parses indexed rules, keyed by "ruleset:rule".
+func parseRuleDocs(docs map[string]*yaraRuleType) (map[string]*ast.Rule, error) {
+	rules := map[string]*ast.Rule{}
+	for _, doc := range docs {
+		rule, err := parseRuleBody(doc.Body)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", doc.ID, err)
+		}
+		rules[doc.RulesetName+":"+doc.RuleName] = rule
+	}
+	return rules, nil
+}
+
+// parseRulesFile parses a ruleset file, keying its rules by name.
+func parseRulesFile(filename string) (map[string]*ast.Rule, error) {
+	ruleset, err := parseYaraFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	rules := map[string]*ast.Rule{}
+	for _, rule := range ruleset.Rules {
+		rules[rule.Identifier] = rule
+	}
+	return rules, nil
+}
+
+func writeValues(out io.Writer, prefix string, values []string) {
+	fmt.Fprintf(out, "%s%s\n", prefix, strings.Join(values, ", "))
+}
+
+func (ruleDiff *ruleDiffType) writeText(out io.Writer) {
+	fmt.Fprintf(out, "~ rule %s\n", ruleDiff.Rule)
+	if len(ruleDiff.AddedModifiers) > 0 {
+		writeValues(out, "    + modifiers: ", ruleDiff.AddedModifiers)
+	}
+	if len(ruleDiff.RemovedModifiers) > 0 {
+		writeValues(out, "    - modifiers: ", ruleDiff.RemovedModifiers)
+	}
+	if len(ruleDiff.AddedTags) > 0 {
+		writeValues(out, "    + tags: ", ruleDiff.AddedTags)
+	}
+	if len(ruleDiff.RemovedTags) > 0 {
+		writeValues(out, "    - tags: ", ruleDiff.RemovedTags)
+	}
+	if len(ruleDiff.Meta) > 0 {
+		fmt.Fprintln(out, "    meta:")
+		for _, meta := range ruleDiff.Meta {
+			switch meta.Change {
+			case changeAdded:
+				writeValues(out, "      + "+meta.Key+" = ", meta.To)
+			case changeRemoved:
+				writeValues(out, "      - "+meta.Key+" = ", meta.From)
+			default:
+				writeValues(out, "      - "+meta.Key+" = ", meta.From)
+				writeValues(out, "      + "+meta.Key+" = ", meta.To)
+			}
+		}
+	}
+	if len(ruleDiff.Strings) > 0 {
+		fmt.Fprintln(out, "    strings:")
+		for _, s := range ruleDiff.Strings {
+			switch s.Change {
+			case changeAdded:
+				fmt.Fprintf(out, "      + %s = %s\n", s.Identifier, s.To)
+			case changeRemoved:
+				fmt.Fprintf(out, "      - %s = %s\n", s.Identifier, s.From)
+			default:
+				if s.From != "" {
+					fmt.Fprintf(out, "      - %s = %s\n", s.Identifier, s.From)
+					fmt.Fprintf(out, "      + %s = %s\n", s.Identifier, s.To)
+				}
+				if len(s.AddedModifiers) > 0 {
+					writeValues(out, "      + "+s.Identifier+" modifiers: ", s.AddedModifiers)
+				}
+				if len(s.RemovedModifiers) > 0 {
+					writeValues(out, "      - "+s.Identifier+" modifiers: ", s.RemovedModifiers)
+				}
+			}
+		}
+	}
+	if ruleDiff.ConditionFrom != "" || ruleDiff.ConditionTo != "" {
+		fmt.Fprintln(out, "    condition:")
+		fmt.Fprintf(out, "      - %s\n", ruleDiff.ConditionFrom)
+		fmt.Fprintf(out, "      + %s\n", ruleDiff.ConditionTo)
+	}
+}
+
+func (result *rulesDiffType) writeText(out io.Writer) {
+	fmt.Fprintf(out, "--- %s\n+++ %s\n", result.From, result.To)
+	for _, name := range result.Added {
+		fmt.Fprintf(out, "+ rule %s\n", name)
+	}
+	for _, name := range result.Removed {
+		fmt.Fprintf(out, "- rule %s\n", name)
+	}
+	for _, ruleDiff := range result.Changed {
+		ruleDiff.writeText(out)
+	}
+}
+
+func (result *rulesDiffType) writeJSON(out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
@@ -25,6 +25,8 @@ func dirExists(filename string) bool {
 	return info.IsDir()
 }
 
+// findFiles calls callback for the files in parent, and in its
+// subdirectories if recursive. Git metadata directories are skipped.
 func findFiles(ctx *YaramanContext, parent string, recursive bool, callback fileCallbackType) error {
 	parent = filepath.Clean(parent)
 	pathExists := dirExists(parent)
@@ -37,6 +39,9 @@ func findFiles(ctx *YaramanContext, parent string, recursive bool, callback file
 			if err != nil {
 				return err
 			}
+			if info.IsDir() && info.Name() == ".git" {
+				return filepath.SkipDir
+			}
 			if !info.Mode().IsRegular() {
 				return nil
 			}
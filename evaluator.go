@@ -0,0 +1,670 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VirusTotal/gyp/ast"
+)
+
+// Error returned when a rule uses something the evaluator cannot handle,
+// such as a module.
+type unsupportedError struct {
+	feature string
+}
+
+func (e *unsupportedError) Error() string {
+	return "unsupported: " + e.feature
+}
+
+func unsupported(format string, a ...interface{}) error {
+	return &unsupportedError{feature: fmt.Sprintf(format, a...)}
+}
+
+// Integer readers and the number of bytes they read.
+var intReaders = map[string]int{
+	"int8": 1, "int16": 2, "int32": 4,
+	"uint8": 1, "uint16": 2, "uint32": 4,
+	"int8be": 1, "int16be": 2, "int32be": 4,
+	"uint8be": 1, "uint16be": 2, "uint32be": 4,
+}
+
+// Strings of a rule with their matches in the scanned data.
+type stringResultType struct {
+	key     string
+	matches []stringMatchType
+}
+
+// State for evaluating the condition of one rule against one file.
+// Values are int64, float64, string or bool, nil means undefined.
+type ruleEvaluatorType struct {
+	data    *scanDataType
+	strings []*stringResultType
+	byKey   map[string]*stringResultType
+	// Loop variables of enclosing for expressions
+	variables map[string]interface{}
+	// String bound to "$" in a for ... of expression
+	current *stringResultType
+	// Evaluates another rule of the ruleset by name
+	evaluateRule func(name string) (bool, error)
+}
+
+func toBool(v interface{}) bool {
+	switch value := v.(type) {
+	case bool:
+		return value
+	case int64:
+		return value != 0
+	case float64:
+		return value != 0
+	case string:
+		return value != ""
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+func (e *ruleEvaluatorType) evalBool(expression ast.Expression) (bool, error) {
+	v, err := e.eval(expression)
+	return toBool(v), err
+}
+
+func (e *ruleEvaluatorType) evalInt(expression ast.Expression) (int64, bool, error) {
+	v, err := e.eval(expression)
+	if err != nil {
+		return 0, false, err
+	}
+	i, ok := v.(int64)
+	return i, ok, nil
+}
+
+// lookupString returns the string for an identifier used in a
+// condition. An empty identifier refers to the string of the enclosing
+// for ... of expression.
+func (e *ruleEvaluatorType) lookupString(identifier string) (*stringResultType, error) {
+	if identifier == "" {
+		if e.current == nil {
+			return nil, fmt.Errorf("$ used outside of a for ... of expression")
+		}
+		return e.current, nil
+	}
+	s, ok := e.byKey[identifier]
+	if !ok {
+		return nil, fmt.Errorf("undefined string $%s", identifier)
+	}
+	return s, nil
+}
+
+// stringSet resolves "them" or an enumeration of strings, which may
+// contain wildcards like $a*.
+func (e *ruleEvaluatorType) stringSet(node ast.Node) ([]*stringResultType, error) {
+	if node == ast.KeywordThem {
+		return e.strings, nil
+	}
+	enum, ok := node.(*ast.Enum)
+	if !ok {
+		return nil, unsupported("string set %T", node)
+	}
+	set := []*stringResultType{}
+	for _, value := range enum.Values {
+		identifier, ok := value.(*ast.StringIdentifier)
+		if !ok {
+			return nil, unsupported("string set item %T", value)
+		}
+		if strings.HasSuffix(identifier.Identifier, "*") {
+			prefix := strings.TrimSuffix(identifier.Identifier, "*")
+			for _, s := range e.strings {
+				if strings.HasPrefix(s.key, prefix) {
+					set = append(set, s)
+				}
+			}
+			continue
+		}
+		s, err := e.lookupString(identifier.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, s)
+	}
+	return set, nil
+}
+
+// quantifierSatisfied checks a count of true items against all, any or
+// a number.
+func (e *ruleEvaluatorType) quantifierSatisfied(quantifier *ast.Quantifier, count int, total int) (bool, error) {
+	switch quantifier.Expression {
+	case ast.KeywordAll:
+		return count == total, nil
+	case ast.KeywordAny:
+		return count > 0, nil
+	}
+	n, ok, err := e.evalInt(quantifier.Expression)
+	if err != nil || !ok {
+		return false, err
+	}
+	return int64(count) >= n, nil
+}
+
+func (e *ruleEvaluatorType) matchedAt(s *stringResultType, offset int64) bool {
+	for _, match := range s.matches {
+		if int64(match.Offset) == offset {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *ruleEvaluatorType) matchedIn(s *stringResultType, r *ast.Range) (interface{}, error) {
+	start, ok1, err := e.evalInt(r.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, ok2, err := e.evalInt(r.End)
+	if err != nil || !ok1 || !ok2 {
+		return nil, err
+	}
+	for _, match := range s.matches {
+		if int64(match.Offset) >= start && int64(match.Offset) <= end {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchIndex returns the match selected by a 1 based index expression,
+// or nil if the index is out of range.
+func (e *ruleEvaluatorType) matchIndex(s *stringResultType, index ast.Expression) (*stringMatchType, error) {
+	i := int64(1)
+	if index != nil {
+		var ok bool
+		var err error
+		i, ok, err = e.evalInt(index)
+		if err != nil || !ok {
+			return nil, err
+		}
+	}
+	if i < 1 || i > int64(len(s.matches)) {
+		return nil, nil
+	}
+	return &s.matches[i-1], nil
+}
+
+func (e *ruleEvaluatorType) readInt(name string, offsetExpression ast.Expression) (interface{}, error) {
+	size := intReaders[name]
+	offset, ok, err := e.evalInt(offsetExpression)
+	if err != nil || !ok {
+		return nil, err
+	}
+	data := e.data.data
+	if offset < 0 || offset+int64(size) > int64(len(data)) {
+		return nil, nil
+	}
+	b := data[offset : offset+int64(size)]
+	bigEndian := strings.HasSuffix(name, "be")
+	signed := !strings.HasPrefix(name, "u")
+	var value uint32
+	switch size {
+	case 1:
+		value = uint32(b[0])
+	case 2:
+		if bigEndian {
+			value = uint32(binary.BigEndian.Uint16(b))
+		} else {
+			value = uint32(binary.LittleEndian.Uint16(b))
+		}
+	case 4:
+		if bigEndian {
+			value = binary.BigEndian.Uint32(b)
+		} else {
+			value = binary.LittleEndian.Uint32(b)
+		}
+	}
+	if !signed {
+		return int64(value), nil
+	}
+	switch size {
+	case 1:
+		return int64(int8(value)), nil
+	case 2:
+		return int64(int16(value)), nil
+	}
+	return int64(int32(value)), nil
+}
+
+func (e *ruleEvaluatorType) evalForIn(f *ast.ForIn) (interface{}, error) {
+	if len(f.Variables) != 1 {
+		return nil, unsupported("for loops over dictionaries")
+	}
+	// Values are visited one at a time, a range may be as large as the file
+	var each func(visit func(int64) (bool, error)) error
+	switch iterator := f.Iterator.(type) {
+	case *ast.Range:
+		start, ok1, err := e.evalInt(iterator.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, ok2, err := e.evalInt(iterator.End)
+		if err != nil {
+			return nil, err
+		}
+		if !ok1 || !ok2 {
+			return false, nil
+		}
+		each = func(visit func(int64) (bool, error)) error {
+			for i := start; i <= end; i++ {
+				done, err := visit(i)
+				if done || err != nil || i == end {
+					return err
+				}
+			}
+			return nil
+		}
+	case *ast.Enum:
+		each = func(visit func(int64) (bool, error)) error {
+			for _, expression := range iterator.Values {
+				v, ok, err := e.evalInt(expression)
+				if err != nil || !ok {
+					if err != nil {
+						return err
+					}
+					continue
+				}
+				done, err := visit(v)
+				if done || err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	default:
+		return nil, unsupported("iterating over %s", rootIdentifier(iterator.(ast.Expression)))
+	}
+
+	// Stop as soon as the result is known
+	needed := int64(-1)
+	switch f.Quantifier.Expression {
+	case ast.KeywordAny:
+		needed = 1
+	case ast.KeywordAll:
+	default:
+		n, ok, err := e.evalInt(f.Quantifier.Expression)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			needed = n
+		}
+	}
+
+	name := f.Variables[0]
+	saved, shadowed := e.variables[name]
+	defer func() {
+		if shadowed {
+			e.variables[name] = saved
+		} else {
+			delete(e.variables, name)
+		}
+	}()
+	count, total := 0, 0
+	err := each(func(v int64) (bool, error) {
+		total++
+		e.variables[name] = v
+		ok, err := e.evalBool(f.Condition)
+		if err != nil {
+			return true, err
+		}
+		if ok {
+			count++
+		} else if f.Quantifier.Expression == ast.KeywordAll {
+			return true, nil
+		}
+		return needed >= 0 && int64(count) >= needed, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return e.quantifierSatisfied(f.Quantifier, count, total)
+}
+
+func (e *ruleEvaluatorType) evalForOf(f *ast.ForOf) (interface{}, error) {
+	set, err := e.stringSet(f.Strings)
+	if err != nil {
+		return nil, err
+	}
+	saved := e.current
+	defer func() { e.current = saved }()
+	count := 0
+	for _, s := range set {
+		e.current = s
+		ok, err := e.evalBool(f.Condition)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			count++
+		}
+	}
+	return e.quantifierSatisfied(f.Quantifier, count, len(set))
+}
+
+func (e *ruleEvaluatorType) evalOf(o *ast.Of) (interface{}, error) {
+	set, err := e.stringSet(o.Strings)
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	for _, s := range set {
+		if len(s.matches) > 0 {
+			count++
+		}
+	}
+	return e.quantifierSatisfied(o.Quantifier, count, len(set))
+}
+
+func compareValues(operator ast.OperatorType, left interface{}, right interface{}) (interface{}, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	var c int
+	ls, leftIsString := left.(string)
+	rs, rightIsString := right.(string)
+	switch {
+	case leftIsString && rightIsString:
+		c = strings.Compare(ls, rs)
+	case leftIsString || rightIsString:
+		return nil, fmt.Errorf("cannot compare %v and %v", left, right)
+	default:
+		li, leftIsInt := left.(int64)
+		ri, rightIsInt := right.(int64)
+		if leftIsInt && rightIsInt {
+			switch {
+			case li < ri:
+				c = -1
+			case li > ri:
+				c = 1
+			}
+			break
+		}
+		lf, ok1 := toFloat(left)
+		rf, ok2 := toFloat(right)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("cannot compare %v and %v", left, right)
+		}
+		switch {
+		case lf < rf:
+			c = -1
+		case lf > rf:
+			c = 1
+		}
+	}
+	switch operator {
+	case ast.OpEqual:
+		return c == 0, nil
+	case ast.OpNotEqual:
+		return c != 0, nil
+	case ast.OpLessThan:
+		return c < 0, nil
+	case ast.OpGreaterThan:
+		return c > 0, nil
+	case ast.OpLessOrEqual:
+		return c <= 0, nil
+	}
+	return c >= 0, nil
+}
+
+func arithmetic(operator ast.OperatorType, left interface{}, right interface{}) (interface{}, error) {
+	if left == nil || right == nil {
+		return nil, nil
+	}
+	li, leftIsInt := left.(int64)
+	ri, rightIsInt := right.(int64)
+	if leftIsInt && rightIsInt {
+		switch operator {
+		case ast.OpAdd:
+			return li + ri, nil
+		case ast.OpSub:
+			return li - ri, nil
+		case ast.OpMul:
+			return li * ri, nil
+		case ast.OpDiv:
+			if ri == 0 {
+				return nil, nil
+			}
+			return li / ri, nil
+		case ast.OpMod:
+			if ri == 0 {
+				return nil, nil
+			}
+			return li % ri, nil
+		case ast.OpBitAnd:
+			return li & ri, nil
+		case ast.OpBitOr:
+			return li | ri, nil
+		case ast.OpBitXor:
+			return li ^ ri, nil
+		case ast.OpShiftLeft:
+			return li << uint64(ri), nil
+		case ast.OpShiftRight:
+			return li >> uint64(ri), nil
+		}
+	}
+	lf, ok1 := toFloat(left)
+	rf, ok2 := toFloat(right)
+	if !ok1 || !ok2 {
+		return nil, fmt.Errorf("invalid operands for %s: %v and %v", operator, left, right)
+	}
+	switch operator {
+	case ast.OpAdd:
+		return lf + rf, nil
+	case ast.OpSub:
+		return lf - rf, nil
+	case ast.OpMul:
+		return lf * rf, nil
+	case ast.OpDiv:
+		if rf == 0 {
+			return nil, nil
+		}
+		return lf / rf, nil
+	}
+	return nil, fmt.Errorf("invalid operands for %s: %v and %v", operator, left, right)
+}
+
+func (e *ruleEvaluatorType) evalOperation(o *ast.Operation) (interface{}, error) {
+	switch o.Operator {
+	case ast.OpAnd:
+		for _, operand := range o.Operands {
+			ok, err := e.evalBool(operand)
+			if err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	case ast.OpOr:
+		for _, operand := range o.Operands {
+			ok, err := e.evalBool(operand)
+			if err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case ast.OpMatches:
+		left, err := e.eval(o.Operands[0])
+		if err != nil {
+			return nil, err
+		}
+		literal, ok := o.Operands[1].(*ast.LiteralRegexp)
+		s, isString := left.(string)
+		if !ok || !isString {
+			return nil, nil
+		}
+		re, err := compileYaraRegexp(literal, false)
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	}
+
+	values := make([]interface{}, len(o.Operands))
+	for i, operand := range o.Operands {
+		v, err := e.eval(operand)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	switch o.Operator {
+	case ast.OpEqual, ast.OpNotEqual, ast.OpLessThan, ast.OpGreaterThan, ast.OpLessOrEqual, ast.OpGreaterOrEqual:
+		return compareValues(o.Operator, values[0], values[1])
+	case ast.OpContains:
+		left, ok1 := values[0].(string)
+		right, ok2 := values[1].(string)
+		if !ok1 || !ok2 {
+			return nil, nil
+		}
+		return strings.Contains(left, right), nil
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		var err error
+		result, err = arithmetic(o.Operator, result, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (e *ruleEvaluatorType) eval(expression ast.Expression) (interface{}, error) {
+	switch v := expression.(type) {
+	case ast.Keyword:
+		switch v {
+		case ast.KeywordTrue:
+			return true, nil
+		case ast.KeywordFalse:
+			return false, nil
+		case ast.KeywordFilesize:
+			return int64(len(e.data.data)), nil
+		}
+		return nil, unsupported("%s", v)
+	case *ast.Group:
+		return e.eval(v.Expression)
+	case *ast.LiteralInteger:
+		return v.Value, nil
+	case *ast.LiteralFloat:
+		return v.Value, nil
+	case *ast.LiteralString:
+		s, err := strconv.Unquote(`"` + v.Value + `"`)
+		if err != nil {
+			return v.Value, nil
+		}
+		return s, nil
+	case *ast.Not:
+		ok, err := e.evalBool(v.Expression)
+		return !ok, err
+	case *ast.Minus:
+		value, err := e.eval(v.Expression)
+		switch number := value.(type) {
+		case int64:
+			return -number, err
+		case float64:
+			return -number, err
+		}
+		return nil, err
+	case *ast.BitwiseNot:
+		i, ok, err := e.evalInt(v.Expression)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return ^i, nil
+	case *ast.Identifier:
+		if value, ok := e.variables[v.Identifier]; ok {
+			return value, nil
+		}
+		return e.evaluateRule(v.Identifier)
+	case *ast.StringIdentifier:
+		s, err := e.lookupString(v.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		if v.At != nil {
+			offset, ok, err := e.evalInt(v.At)
+			if err != nil || !ok {
+				return false, err
+			}
+			return e.matchedAt(s, offset), nil
+		}
+		if v.In != nil {
+			return e.matchedIn(s, v.In)
+		}
+		return len(s.matches) > 0, nil
+	case *ast.StringCount:
+		s, err := e.lookupString(v.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		return int64(len(s.matches)), nil
+	case *ast.StringOffset:
+		s, err := e.lookupString(v.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		match, err := e.matchIndex(s, v.Index)
+		if err != nil || match == nil {
+			return nil, err
+		}
+		return int64(match.Offset), nil
+	case *ast.StringLength:
+		s, err := e.lookupString(v.Identifier)
+		if err != nil {
+			return nil, err
+		}
+		match, err := e.matchIndex(s, v.Index)
+		if err != nil || match == nil {
+			return nil, err
+		}
+		return int64(match.Length), nil
+	case *ast.FunctionCall:
+		if identifier, ok := v.Callable.(*ast.Identifier); ok {
+			if _, ok := intReaders[identifier.Identifier]; ok && len(v.Arguments) == 1 {
+				return e.readInt(identifier.Identifier, v.Arguments[0])
+			}
+		}
+		return nil, unsupported("module %s", rootIdentifier(v))
+	case *ast.MemberAccess, *ast.Subscripting:
+		return nil, unsupported("module %s", rootIdentifier(v))
+	case *ast.Of:
+		return e.evalOf(v)
+	case *ast.ForOf:
+		return e.evalForOf(v)
+	case *ast.ForIn:
+		return e.evalForIn(v)
+	case *ast.Operation:
+		return e.evalOperation(v)
+	}
+	return nil, unsupported("expression %T", expression)
+}
+
+// unsupportedFeatures lists what a condition uses that the evaluator
+// does not support, mainly modules. These rules are not evaluated.
+func unsupportedFeatures(rule *ast.Rule) []string {
+	info := analyzeCondition(rule)
+	features := []string{}
+	if containsString(info.Features, featureEntrypoint) {
+		features = append(features, featureEntrypoint)
+	}
+	for _, module := range info.Modules {
+		features = append(features, "module "+module)
+	}
+	return features
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+// testRuleMatches evaluates a rule of its own ruleset against data.
+func testRuleMatches(t *testing.T, body string, data string) bool {
+	t.Helper()
+	scanRule := newScanRule(&yaraRuleType{RulesetName: "test", RuleName: "test", Body: body})
+	if len(scanRule.unsupported) > 0 {
+		t.Fatalf("%s: unsupported: %v", body, scanRule.unsupported)
+	}
+	scanner := &scannerType{
+		rulesets:    map[string]map[string]*scanRuleType{"test": {"test": scanRule}},
+		unsupported: map[string]*unsupportedRuleType{},
+	}
+	matched, err := scanner.matchRule(scanRule, []byte(data))
+	if err != nil {
+		t.Fatalf("%s: %v", body, err)
+	}
+	return matched
+}
+
+func TestEvaluator(t *testing.T) {
+	tests := []struct {
+		strings   string
+		condition string
+		data      string
+		match     bool
+	}{
+		{`$a = "ab"`, `#a == 3`, "ab ab ab", true},
+		{`$a = "ab"`, `#a == 2`, "ab ab ab", false},
+		{`$a = "ab"`, `@a[1] == 2 and @a[2] == 5`, "xxab ab", true},
+		{`$a = "ab"`, `@a[3] == 0`, "ab ab", false},
+		{`$a = /a+/`, `!a[1] == 3`, "aaa", true},
+		{`$a = "ab"`, `$a at 2`, "xxab", true},
+		{`$a = "ab"`, `$a in (0..1)`, "xxab", false},
+		{`$a = "ab" $b = "cd" $c = "ef"`, `2 of them`, "ab cd", true},
+		{`$a = "ab" $b = "cd" $c = "ef"`, `all of them`, "ab cd", false},
+		{`$a = "ab" $b = "cd" $c = "ef"`, `any of ($b, $c)`, "ab cd", true},
+		{`$a = "ab" $b = "cd"`, `for all of them : (@ < 10)`, "ab cd", true},
+		{`$a = "ab" $b = "cd"`, `for any of them : (# > 1)`, "ab cd", false},
+		{`$a = "ab"`, `for all i in (1..#a) : (@a[i] % 3 == 0)`, "ab ab ab", true},
+		{`$a = "ab"`, `for any i in (1..#a) : (@a[i] == 6)`, "ab ab ab", true},
+		{`$a = "ab"`, `for 2 i in (1, 2, 3) : (@a[i] > 0)`, "ab ab ab", true},
+		{`$a = "ab"`, `for all i in (1..3) : (@a[i] > 0)`, "ab ab ab", false},
+		{`$a = "ab"`, `for any i in (1..0x7fffffffffffffff) : (i == 1)`, "", true},
+		{`$a = "ab"`, `for all i in (0..0x7fffffffffffffff) : (i > 0)`, "", false},
+		{`$a = "ab"`, `filesize == 4 and not $a`, "abcd", false},
+	}
+	for _, test := range tests {
+		body := "rule test { strings: " + test.strings + " condition: " + test.condition + " }"
+		if testRuleMatches(t, body, test.data) != test.match {
+			t.Errorf("%s on %q: expected %v", test.condition, test.data, test.match)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// writeYaraRules writes rules as one ruleset, preceded by the imports of
+// the modules they use.
+func writeYaraRules(out io.Writer, docs []*yaraRuleType) error {
+	imports := MapSet{}
+	for _, doc := range docs {
+		rule, err := parseRuleBody(doc.Body)
+		if err != nil {
+			return fmt.Errorf("rule %s: %v", doc.ID, err)
+		}
+		for _, module := range analyzeCondition(rule).Modules {
+			imports.Add(module)
+		}
+	}
+	modules := []string{}
+	for module := range imports {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		fmt.Fprintf(out, "import \"%s\"\n", module)
+	}
+	if len(modules) > 0 {
+		fmt.Fprintln(out)
+	}
+	if len(docs) > 0 {
+		writeAttribution(out, docs)
+	}
+	for _, doc := range docs {
+		source := doc.RulesetName
+		if doc.License != "" {
+			source += " (" + doc.License + ")"
+		}
+		fmt.Fprintf(out, "// %s\n%s\n\n", commentText(source), strings.TrimSpace(doc.Body))
+	}
+	return nil
+}
+
+// filterYaraVersion removes the rules that need a newer YARA version
+// than the given one, listing them on report.
+func filterYaraVersion(docs []*yaraRuleType, version yaraVersionType, report io.Writer) ([]*yaraRuleType, error) {
+	compatible := []*yaraRuleType{}
+	for _, doc := range docs {
+		rule, err := parseRuleBody(doc.Body)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", doc.ID, err)
+		}
+		features := unsupportedBy(versionRequirements(rule), version)
+		if len(features) > 0 {
+			fmt.Fprintf(report, "excluded %s %s:%s, YARA %s does not support %s\n",
+				doc.ID, doc.RulesetName, doc.RuleName, version, strings.Join(features, ", "))
+			continue
+		}
+		compatible = append(compatible, doc)
+	}
+	return compatible, nil
+}
+
+// Formats that export the source of rules, which needs the rules they
+// refer to
+var sourceFormats = []string{"yara", "stix", "misp"}
+
+// ruleDependencies returns the rules each of docs refers to, directly or
+// through other rules, keyed by rule ID.
+func ruleDependencies(ctx *YaramanContext, docs []*yaraRuleType) (map[string][]*yaraRuleType, error) {
+	result := map[string][]*yaraRuleType{}
+	refs := false
+	for _, doc := range docs {
+		refs = refs || len(doc.RuleRefs) > 0
+	}
+	if !refs {
+		return result, nil
+	}
+	all, err := searchRules(ctx, buildQuery(""))
+	if err != nil {
+		return nil, err
+	}
+	graph := buildRuleGraph(all)
+	for _, doc := range docs {
+		deps, err := graph.dependencies(doc.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(deps) > 0 {
+			result[doc.ID] = deps
+		}
+	}
+	return result, nil
+}
+
+// withDependencies adds the rules docs refer to, each before the first
+// rule referring to it.
+func withDependencies(docs []*yaraRuleType, deps map[string][]*yaraRuleType) []*yaraRuleType {
+	result := []*yaraRuleType{}
+	added := MapSet{}
+	for _, doc := range docs {
+		for _, dep := range append(deps[doc.ID], doc) {
+			if !added.Contains(dep.ID) {
+				added.Add(dep.ID)
+				result = append(result, dep)
+			}
+		}
+	}
+	return result
+}
+
+// Settings of the export formats that have any.
+type exportOptionsType struct {
+	// Columns of CSV exports
+	Columns []string
+	// Grouping of Markdown and HTML catalogs
+	GroupBy string
+	// Info of exported MISP events
+	EventInfo string
+	// Rules the exported rules refer to, by rule ID
+	Dependencies map[string][]*yaraRuleType
+}
+
+// writeExport writes rules in one of the export formats.
+func writeExport(out io.Writer, format string, docs []*yaraRuleType, options *exportOptionsType) error {
+	switch format {
+	case "json", "jsonl":
+		encoder := json.NewEncoder(out)
+		for _, doc := range docs {
+			err := encoder.Encode(struct {
+				*yaraRuleType
+				Attribution string `json:"attribution"`
+			}{doc, ruleAttribution(doc)})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		return writeCSV(out, docs, options.Columns)
+	case "markdown":
+		writeMarkdownCatalog(out, docs, options.GroupBy)
+		return nil
+	case "html":
+		return writeHTMLCatalog(out, docs, options.GroupBy)
+	case "stix":
+		return writeSTIX(out, docs, options.Dependencies)
+	case "misp":
+		return writeMISPEvent(out, docs, options.EventInfo, options.Dependencies)
+	}
+	return writeYaraRules(out, withDependencies(docs, options.Dependencies))
+}
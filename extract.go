@@ -11,10 +11,11 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
-	"github.com/VirusTotal/gyp"
 	"github.com/VirusTotal/gyp/ast"
 	jsonpb "github.com/golang/protobuf/jsonpb"
 )
@@ -47,7 +48,63 @@ type yaraRuleType struct {
 
 	// allow for multiple values per metadata key
 	Metadata map[string][]string `json:"metadata"`
-	Body     string              `json:"body"`
+	// Dates in the metadata as written, normalized dates are in Metadata
+	Dates []*ruleDateType `json:"dates,omitempty"`
+	Body  string          `json:"body"`
+	// String definitions, also indexed as separate documents
+	Strings []*stringDefType `json:"strings,omitempty"`
+	// What the condition uses, see conditionInfoType
+	Modules           []string `json:"modules,omitempty"`
+	Functions         []string `json:"functions,omitempty"`
+	ModuleFields      []string `json:"module_fields,omitempty"`
+	RuleRefs          []string `json:"rule_refs,omitempty"`
+	ConditionFeatures []string `json:"condition_features,omitempty"`
+	// Oldest YARA version that can compile the rule and the features that
+	// need a version newer than 3.0
+	MinYaraVersion string   `json:"min_yara_version,omitempty"`
+	YaraFeatures   []string `json:"yara_features,omitempty"`
+	// MITRE ATT&CK technique and tactic IDs found in the metadata, tags and
+	// name of the rule
+	AttackTechniques []string `json:"attack_techniques,omitempty"`
+	AttackTactics    []string `json:"attack_tactics,omitempty"`
+	// Canonical names of the actors and malware families the rule is
+	// about, see aliasDictionaryType
+	Actor         []string `json:"actor,omitempty"`
+	MalwareFamily []string `json:"malware_family,omitempty"`
+	// First normalized date of each date field in Metadata and the day the
+	// rule was first imported, indexed as dates for range queries
+	CreationDate string `json:"creation_date,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	ReleaseDate  string `json:"release_date,omitempty"`
+	Imported     string `json:"imported,omitempty"`
+	// Score from 0 to 100 and the quality checks the rule fails, see
+	// scoreRule
+	Quality       int      `json:"quality"`
+	QualityIssues []string `json:"quality_issues,omitempty"`
+	// SPDX identifier of the license of the rule and where it was found,
+	// meta, spdx or file: followed by the license file
+	License       string `json:"license,omitempty"`
+	LicenseSource string `json:"license_source,omitempty"`
+	// Date of the last git commit that changed the rule, if known
+	LastChanged string `json:"last_changed,omitempty"`
+	// Feed the rule was synced from and the trust level of the feed
+	Feed  string `json:"feed,omitempty"`
+	Trust string `json:"trust,omitempty"`
+	// MISP event the rule was imported from
+	MISPEvent     string `json:"misp_event,omitempty"`
+	MISPEventInfo string `json:"misp_event_info,omitempty"`
+	// Number of goodware files the rule matched in the last fptest run,
+	// nil if it has not been tested
+	FPHits   *int   `json:"fp_hits,omitempty"`
+	FPTested string `json:"fp_tested,omitempty"`
+	// Indicators found in the strings and metadata of the rule
+	IOCDomain   []string `json:"ioc_domain,omitempty"`
+	IOCIP       []string `json:"ioc_ip,omitempty"`
+	IOCURL      []string `json:"ioc_url,omitempty"`
+	IOCHash     []string `json:"ioc_hash,omitempty"`
+	IOCMutex    []string `json:"ioc_mutex,omitempty"`
+	IOCPDB      []string `json:"ioc_pdb,omitempty"`
+	IOCRegistry []string `json:"ioc_registry,omitempty"`
 }
 
 const (
@@ -61,42 +118,8 @@ type rulesetCallbackFunc func(ctx *YaramanContext, rulesetName string, ruleset *
 var (
 	camelRE  = regexp.MustCompile(camelCasePattern)
 	abbrevRE = regexp.MustCompile(abbrevPattern)
-
-	// Map to standardize yara meta tags to CCCS (mostly). Some
-	// entries are mapped because they were found to be in use.
-	// https://github.com/CybercentreCanada/CCCS-Yara/blob/master/CCCS_YARA.yml
-	//
-	// For example, description and desc both map to "description"
-	// if a tag is not in this map, just use it as-as.
-	// When matching against the string key, do not just do a
-	// map lookup but iterate over the keys and match the prefix.
-	// e.g. hash* will always map to "hash". Always iterate over
-	// every prefix and match the longest one. This is to ensure
-	// that ref_hash maps to hash not reference, etc.
-	// Setting the value to space leaves the metatag as-is.
-	normalizedMetaTags = map[string]string{}
 )
 
-func readNormalizedMetaTags(filename string) error {
-	data, err := ioutil.ReadFile(filename)
-	if err != nil {
-		return err
-	}
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		parts := strings.Split(line, ",")
-		if len(parts) == 0 {
-			continue
-		}
-		if len(parts) == 1 {
-			normalizedMetaTags[parts[0]] = parts[0]
-			continue
-		}
-		normalizedMetaTags[parts[0]] = parts[1]
-	}
-	return nil
-}
-
 // Split rule names into words to be used as tags
 // by dividing and conquering.
 // 1. Split into sections separated by "_"
@@ -150,30 +173,6 @@ func splitRuleName(name string) []string {
 	return result
 }
 
-// lookupMetaFieldname converts a variety of non-standard
-// metadata field names to consistent names
-func lookupMetaFieldname(fromName string) string {
-	bestSoFar := ""
-	for k, v := range normalizedMetaTags {
-		if strings.HasPrefix(fromName, k) {
-			// if v is "" then name should be kept as-is
-			if fromName == k && v == "" {
-				return fromName
-			}
-			if len(v) >= len(bestSoFar) {
-				bestSoFar = v
-			}
-		}
-	}
-	if bestSoFar == "" {
-		return fromName
-	}
-	if fromName != bestSoFar {
-		logger.Trace().Str("from_name", fromName).Str("to_name", bestSoFar).Msg("metadata map")
-	}
-	return bestSoFar
-}
-
 func ruleToJSON(rule *ast.Rule, out io.Writer) error {
 	marshaler := jsonpb.Marshaler{
 		Indent: "",
@@ -192,29 +191,35 @@ func makeID(rulesetName string, ruleName string) string {
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
-func extractMetadata(metadata []*ast.Meta) map[string][]string {
+// metaValue returns the value of a metadata entry as a string.
+// Meta.String returns the whole "key = value" source instead.
+func metaValue(meta *ast.Meta) string {
+	switch v := meta.Value.(type) {
+	case string:
+		return (&ast.TextString{Value: v}).UnescapedValue()
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(meta.Value)
+}
+
+// extractMetadata normalizes the metadata keys of a rule and transforms
+// their values as configured by the mapping file. Dates are also returned
+// as written, including those that could not be parsed.
+func extractMetadata(metadata []*ast.Meta, dateOrder string) (map[string][]string, []*ruleDateType) {
 	result := map[string][]string{}
+	dates := []*ruleDateType{}
 	for _, meta := range metadata {
-		normalizedKey := lookupMetaFieldname(strings.ToLower(meta.Key))
-		if normalizedKey == "creation_date" ||
-			normalizedKey == "last_modified" ||
-			normalizedKey == "release_date" {
-			normalizedDate := normalizeDate(meta.String())
-			if normalizedDate != "" {
-				if result[normalizedKey] == nil {
-					result[normalizedKey] = []string{}
-				}
-				result[normalizedKey] = append(result[normalizedKey], normalizedDate)
-				logger.Trace().Str("original_date", meta.String()).Str("normalized_date", normalizedDate).Msg("metadata date")
-			}
-			continue
-		}
-		if result[normalizedKey] == nil {
-			result[normalizedKey] = []string{}
+		normalizedKey, mapping := resolveMetaKey(meta.Key)
+		values, metaDates := transformMetaValue(normalizedKey, mapping, metaValue(meta), dateOrder)
+		if len(values) > 0 {
+			result[normalizedKey] = append(result[normalizedKey], values...)
 		}
-		result[normalizedKey] = append(result[normalizedKey], meta.String())
+		dates = append(dates, metaDates...)
 	}
-	return result
+	return result, dates
 }
 
 func makeJSON(rulesetName string, rule *ast.Rule) {
@@ -237,7 +242,47 @@ func makeRuleDoc(ctx *YaramanContext, rulesetName string, rule *ast.Rule) {
 		RuleTags: append([]string{}, rule.Tags...),
 		UserTags: []string{},
 		Body:     buf.String(),
-		Metadata: extractMetadata(rule.Meta),
+	}
+	dateOrder := ctx.dateOrder
+	if ctx.feed != nil && ctx.feed.DateOrder != "" {
+		dateOrder = ctx.feed.DateOrder
+	}
+	newDoc.Metadata, newDoc.Dates = extractMetadata(rule.Meta, dateOrder)
+	setRuleDates(newDoc)
+	newDoc.Imported = time.Now().UTC().Format("2006-01-02")
+	for _, s := range rule.Strings {
+		newDoc.Strings = append(newDoc.Strings, describeString(s))
+	}
+	info := analyzeCondition(rule)
+	newDoc.Modules = info.Modules
+	newDoc.Functions = info.Functions
+	newDoc.ModuleFields = info.ModuleFields
+	newDoc.RuleRefs = info.RuleRefs
+	newDoc.ConditionFeatures = info.Features
+	requirements := versionRequirements(rule)
+	newDoc.MinYaraVersion = minYaraVersion(requirements).String()
+	for _, requirement := range requirements {
+		newDoc.YaraFeatures = append(newDoc.YaraFeatures, requirement.Feature)
+	}
+	setRuleIOCs(newDoc, extractIOCs(rule, newDoc.Metadata))
+	newDoc.AttackTechniques, newDoc.AttackTactics = extractAttack(newDoc)
+	setRuleAliases(newDoc, extractAliases(newDoc))
+	newDoc.Quality, newDoc.QualityIssues = scoreRule(newDoc)
+	setRuleLicense(newDoc, ctx.license)
+	if ctx.feed != nil {
+		newDoc.Feed = ctx.feed.Name
+		newDoc.Trust = ctx.feed.Trust
+		newDoc.UserTags = append(newDoc.UserTags, ctx.feed.Tags...)
+	}
+	if ctx.misp != nil {
+		newDoc.MISPEvent = ctx.misp.event.UUID
+		newDoc.MISPEventInfo = ctx.misp.event.Info
+		// Exported rules carry their own tags as attribute tags
+		for _, tag := range ctx.misp.tags {
+			if !containsString(newDoc.RuleTags, tag) && !containsString(newDoc.UserTags, tag) {
+				newDoc.UserTags = append(newDoc.UserTags, tag)
+			}
+		}
 	}
 	logger.Trace().Str("ruleset_name", newDoc.RulesetName).
 		Str("rulename", newDoc.RuleName).
@@ -246,6 +291,10 @@ func makeRuleDoc(ctx *YaramanContext, rulesetName string, rule *ast.Rule) {
 	for k, v := range newDoc.Metadata {
 		logger.Trace().Strs(k, v).Msg("yaradoc metadata")
 	}
+	err := addRuleDoc(ctx, newDoc)
+	if err != nil {
+		errorLogger.Error().AnErr("error", err).Str("ruleset_name", rulesetName).Str("rulename", rule.Identifier).Msg("Could not index rule.")
+	}
 }
 
 func rulesetURLToRulesDir(ctx *YaramanContext, rulesetName string) (string, error) {
@@ -280,30 +329,48 @@ func makeRulesetDoc(ctx *YaramanContext, rulesetName string, ruleset *ast.RuleSe
 	}
 }
 
-func parseRuleset(ctx *YaramanContext, rulesetName string, reader io.Reader, rulesetCallback rulesetCallbackFunc, ruleCallback ruleCallbackFunc) error {
-	ruleset, err := gyp.Parse(reader)
+// parseRuleset parses a ruleset and calls the callbacks for the ruleset
+// and each of its rules. It returns the number of rules in the ruleset.
+func parseRuleset(ctx *YaramanContext, rulesetName string, reader io.Reader, rulesetCallback rulesetCallbackFunc, ruleCallback ruleCallbackFunc) (int, error) {
+	// Comments are dropped by the parser, so look for an SPDX identifier first
+	data, err := ioutil.ReadAll(reader)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	ruleset, err := parseYara(string(data))
+	if err != nil {
+		return 0, err
 	}
+	ctx.license = rulesetLicense(ctx, rulesetName, string(data))
 
 	rulesetCallback(ctx, rulesetName, ruleset)
 
 	for _, rule := range ruleset.Rules {
 		ruleCallback(ctx, rulesetName, rule)
 	}
-	return nil
+	return len(ruleset.Rules), nil
 }
 
-func parseRulesetFile(ctx *YaramanContext, filename string, rulesetCallback rulesetCallbackFunc, ruleCallback ruleCallbackFunc) error {
+// parseYaraFile parses a ruleset file without calling any callbacks.
+func parseYaraFile(filename string) (*ast.RuleSet, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return parseYara(string(data))
+}
+
+func parseRulesetFile(ctx *YaramanContext, filename string, rulesetCallback rulesetCallbackFunc, ruleCallback ruleCallbackFunc) (int, error) {
 	file, err := os.Open(filename)
 	if err != nil {
 		errorLogger.Error().AnErr("error", err).Str("filename", filename).Msg("Could not open file.")
+		return 0, err
 	}
 	defer file.Close()
 
-	err = parseRuleset(ctx, filename, file, rulesetCallback, ruleCallback)
+	rules, err := parseRuleset(ctx, filename, file, rulesetCallback, ruleCallback)
 	if err != nil {
 		errorLogger.Error().AnErr("error", err).Str("filename", filename).Msg("Error parsing ruleset")
 	}
-	return nil
+	return rules, err
 }
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+const (
+	feedTypeGit = "git"
+	feedTypeURL = "url"
+	feedTypeDir = "dir"
+)
+
+// Feed of YARA rules defined by a [[feeds]] section in yaraman.toml.
+type feedType struct {
+	Name string `toml:"name"`
+	// One of git, url or dir
+	Type string `toml:"type"`
+	// Repository URL, file URL or directory
+	Location string `toml:"location"`
+	// Branch to check out for git feeds, the remote's default if empty
+	Branch string `toml:"branch"`
+	// Globs matched against paths relative to the feed root. "*" does not
+	// match "/", "**" does.
+	Include []string `toml:"include"`
+	Exclude []string `toml:"exclude"`
+	Trust   string   `toml:"trust"`
+	// Tags added as user tags to every rule of the feed
+	Tags []string `toml:"tags"`
+	// Order of day and month in dates like 03/04/2019, us or eu
+	DateOrder string `toml:"date_order"`
+
+	includeREs []*regexp.Regexp
+	excludeREs []*regexp.Regexp
+}
+
+// globToRegexp converts a glob to an anchored regular expression.
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var builder strings.Builder
+	builder.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			switch {
+			case strings.HasPrefix(glob[i:], "**/"):
+				// Any number of directories, including none
+				builder.WriteString("(?:.*/)?")
+				i += 2
+			case strings.HasPrefix(glob[i:], "**"):
+				builder.WriteString(".*")
+				i++
+			default:
+				builder.WriteString("[^/]*")
+			}
+		case '?':
+			builder.WriteString("[^/]")
+		default:
+			builder.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	builder.WriteString("$")
+	return regexp.Compile(builder.String())
+}
+
+func compileGlobs(globs []string) ([]*regexp.Regexp, error) {
+	result := []*regexp.Regexp{}
+	for _, glob := range globs {
+		re, err := globToRegexp(glob)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %s: %v", glob, err)
+		}
+		result = append(result, re)
+	}
+	return result, nil
+}
+
+func matchesAny(res []*regexp.Regexp, path string) bool {
+	for _, re := range res {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadFeeds reads the [[feeds]] sections of the configuration.
+func loadFeeds(config *toml.Tree) ([]*feedType, error) {
+	feeds := []*feedType{}
+	trees, ok := config.Get("feeds").([]*toml.Tree)
+	if !ok {
+		return feeds, nil
+	}
+	names := MapSet{}
+	for i, tree := range trees {
+		feed := &feedType{}
+		err := tree.Unmarshal(feed)
+		if err != nil {
+			return nil, fmt.Errorf("feed %d: %v", i+1, err)
+		}
+		err = feed.validate()
+		if err != nil {
+			return nil, fmt.Errorf("feed %d: %v", i+1, err)
+		}
+		if names.Contains(feed.Name) {
+			return nil, fmt.Errorf("feed %d: duplicate feed name %s", i+1, feed.Name)
+		}
+		names.Add(feed.Name)
+		feeds = append(feeds, feed)
+	}
+	return feeds, nil
+}
+
+func (feed *feedType) validate() error {
+	var err error
+
+	if feed.Location == "" {
+		return fmt.Errorf("location is required")
+	}
+	switch feed.Type {
+	case feedTypeGit, feedTypeURL, feedTypeDir:
+	default:
+		return fmt.Errorf("unknown feed type %q, expected git, url or dir", feed.Type)
+	}
+	if feed.Name == "" {
+		feed.Name = feed.Location
+	}
+	err = validateDateOrder(feed.DateOrder)
+	if err != nil {
+		return err
+	}
+	feed.includeREs, err = compileGlobs(feed.Include)
+	if err != nil {
+		return err
+	}
+	feed.excludeREs, err = compileGlobs(feed.Exclude)
+	return err
+}
+
+// selects reports whether a file, given relative to the feed root, is
+// part of the feed.
+func (feed *feedType) selects(relativePath string) bool {
+	relativePath = filepath.ToSlash(relativePath)
+	if len(feed.includeREs) > 0 && !matchesAny(feed.includeREs, relativePath) {
+		return false
+	}
+	return !matchesAny(feed.excludeREs, relativePath)
+}
+
+// localPath returns where the rules of a feed are on disk. Git and URL
+// feeds are stored in the rules directory by host and path.
+func (feed *feedType) localPath(ctx *YaramanContext) (string, error) {
+	if feed.Type == feedTypeDir {
+		return feed.Location, nil
+	}
+	return urlLocalPath(ctx, feed.Location)
+}
+
+// urlLocalPath returns where a file or repository downloaded from a URL is
+// stored, in the rules directory by host and path.
+func urlLocalPath(ctx *YaramanContext, location string) (string, error) {
+	parsedURL, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	if parsedURL.Host == "" {
+		return "", fmt.Errorf("%s is not a URL", location)
+	}
+	path := strings.TrimSuffix(parsedURL.Path, ".git")
+	return ctx.rulesDir + string(os.PathSeparator) + parsedURL.Host + filepath.FromSlash(path), nil
+}
+
+func downloadFile(fileURL string, dest string) error {
+	resp, err := http.Get(fileURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", fileURL, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dest, data, 0644)
+}
+
+// update brings the local copy of a feed up to date.
+func (feed *feedType) update(ctx *YaramanContext, path string) error {
+	switch feed.Type {
+	case feedTypeGit:
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+		return getGithubRepo(feed.Location, path, feed.Branch)
+	case feedTypeURL:
+		return downloadFile(feed.Location, path)
+	}
+	if !dirExists(path) {
+		return fmt.Errorf("directory %s does not exist", path)
+	}
+	return nil
+}
+
+// syncFeed updates a feed and imports its rules as one import.
+func syncFeed(ctx *YaramanContext, feed *feedType, indexHistory bool) (*importReportType, error) {
+	path, err := feed.localPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info().Str("feed", feed.Name).Str("location", feed.Location).Str("path", path).Msg("Syncing feed")
+	err = feed.update(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.feed = feed
+	ctx.report = newImportReport("feed", feed.Name)
+	clearDirectoryLicenses()
+	defer func() {
+		ctx.feed = nil
+		ctx.report = nil
+	}()
+
+	if feed.Type == feedTypeURL {
+		err = yaraFileFunc(ctx, path)
+	} else {
+		ctx.report.Commit = gitHeadCommit(path)
+		err = findFiles(ctx, path, true, func(ctx *YaramanContext, filename string) error {
+			relativePath, err := filepath.Rel(path, filename)
+			if err != nil {
+				return err
+			}
+			if !feed.selects(relativePath) {
+				return nil
+			}
+			return yaraFileFunc(ctx, filename)
+		})
+	}
+	if err != nil {
+		return nil, abortImport(ctx, err)
+	}
+	return finishImport(ctx, indexHistory)
+}
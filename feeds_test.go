@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob  string
+		path  string
+		match bool
+	}{
+		{"**/*.yar", "top.yar", true},
+		{"**/*.yar", "a/b/c.yar", true},
+		{"**/*.yar", "a/b/c.yara", false},
+		{"rules/**/*.yar", "rules/x.yar", true},
+		{"rules/**/*.yar", "rules/a/x.yar", true},
+		{"rules/**/*.yar", "other/x.yar", false},
+		{"rules/**", "rules/a/b", true},
+		{"*.yar", "a/b.yar", false},
+		{"?.yar", "a.yar", true},
+	}
+	for _, test := range tests {
+		re, err := globToRegexp(test.glob)
+		if err != nil {
+			t.Fatalf("%s: %v", test.glob, err)
+		}
+		if re.MatchString(test.path) != test.match {
+			t.Errorf("%s matching %s: expected %v", test.glob, test.path, test.match)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+// False positive hits of a rule on a goodware corpus.
+type fpResultType struct {
+	ID      string   `json:"id"`
+	Ruleset string   `json:"ruleset"`
+	Rule    string   `json:"rule"`
+	Hits    int      `json:"hits"`
+	Files   []string `json:"files,omitempty"`
+}
+
+// Maximum number of matching files listed per rule
+const maxFPFiles = 10
+
+// runFPTest scans a goodware corpus with the given rules and stores the
+// number of matching files of every evaluated rule in its fp_hits field.
+// Private rules and rules that could not be evaluated are not recorded.
+func runFPTest(ctx *YaramanContext, docs []*yaraRuleType, corpus string, recursive bool) ([]*fpResultType, []*unsupportedRuleType, error) {
+	scanner, err := newScanner(ctx, docs)
+	if err != nil {
+		return nil, nil, err
+	}
+	results := map[string]*fpResultType{}
+	for _, doc := range docs {
+		results[doc.ID] = &fpResultType{ID: doc.ID, Ruleset: doc.RulesetName, Rule: doc.RuleName}
+	}
+	files := 0
+	err = scanner.scanPaths(ctx, []string{corpus}, recursive, func(scan *scanResultType) error {
+		files++
+		for _, match := range scan.Matches {
+			result := results[match.ID]
+			result.Hits++
+			if len(result.Files) < maxFPFiles {
+				result.Files = append(result.Files, scan.File)
+			}
+		}
+		for _, ruleError := range scan.Errors {
+			errorLogger.Error().Str("id", ruleError.ID).Str("filename", scan.File).Msg(ruleError.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	logger.Info().Int("files", files).Int("rules", len(docs)).Msg("Scanned corpus")
+
+	unsupportedRules := scanner.unsupportedRules()
+	skipped := MapSet{}
+	for _, rule := range unsupportedRules {
+		skipped.Add(rule.ID)
+	}
+	tested := time.Now().UTC().Format(time.RFC3339)
+	fpResults := []*fpResultType{}
+	for _, doc := range docs {
+		if doc.Private || skipped.Contains(doc.ID) {
+			continue
+		}
+		result := results[doc.ID]
+		hits := result.Hits
+		doc.FPHits = &hits
+		doc.FPTested = tested
+		err = indexYaraRule(ctx, doc)
+		if err != nil {
+			return nil, nil, err
+		}
+		fpResults = append(fpResults, result)
+	}
+	err = flushBatch(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.SliceStable(fpResults, func(i, j int) bool { return fpResults[i].Hits > fpResults[j].Hits })
+	return fpResults, unsupportedRules, nil
+}
+
+// maxFPHitsQuery matches rules that were tested and matched at most max
+// goodware files.
+func maxFPHitsQuery(max int) query.Query {
+	upper := float64(max)
+	inclusive := true
+	hitsQuery := bleve.NewNumericRangeInclusiveQuery(nil, &upper, nil, &inclusive)
+	hitsQuery.SetField("fp_hits")
+	return hitsQuery
+}
+
+func writeFPResults(out io.Writer, format string, results []*fpResultType, unsupportedRules []*unsupportedRuleType) error {
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(struct {
+			Results     []*fpResultType        `json:"results"`
+			Unsupported []*unsupportedRuleType `json:"unsupported"`
+		}{results, unsupportedRules})
+	}
+	for _, result := range results {
+		fmt.Fprintf(out, "%6d %s %s:%s\n", result.Hits, result.ID, result.Ruleset, result.Rule)
+		for _, file := range result.Files {
+			fmt.Fprintf(out, "         %s\n", file)
+		}
+	}
+	writeUnsupportedText(out, unsupportedRules)
+	return nil
+}
@@ -1,5 +1,20 @@
 package main
 
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/analysis/token/lowercase"
+	"github.com/blevesearch/bleve/analysis/token/stop"
+	"github.com/blevesearch/bleve/analysis/tokenizer/single"
+	"github.com/blevesearch/bleve/analysis/tokenizer/unicode"
+	"github.com/blevesearch/bleve/analysis/tokenmap"
+	"github.com/blevesearch/bleve/mapping"
+)
+
 var yaraKeywords = []interface{}{
 	"all",
 	"and",
@@ -46,31 +61,233 @@ var yaraKeywords = []interface{}{
 	"xor",
 }
 
-func initializeBleve() {
-	/*
-		var m *indexMapping = index.Mapping()
+const (
+	indexName          = "yaraman.bleve"
+	yaraAnalyzerName   = "yara"
+	yaraStopTokenMap   = "yara_stop_map"
+	yaraStopFilter     = "yara_stop_filter"
+	ruleDocPrefix      = "rule:"
+	maxBatchOperations = 1000
+)
 
-		err := m.AddCustomTokenFilter("yara_stop_filter", map[string]interface{}{
-			"type":   bleve.stop_tokens_filter.Name,
-			"tokens": yaraKeywords,
-		})
-		if err != nil {
-			errorLog.Fatal(err)
-		}
+// buildIndexMapping creates the bleve mapping for yaraRuleType documents.
+// Identifiers are indexed as keywords so they can be matched exactly and
+// listed, rule bodies are analyzed with a stop list of YARA keywords.
+func buildIndexMapping() (*mapping.IndexMappingImpl, error) {
+	indexMapping := bleve.NewIndexMapping()
+
+	err := indexMapping.AddCustomTokenMap(yaraStopTokenMap, map[string]interface{}{
+		"type":   tokenmap.Name,
+		"tokens": yaraKeywords,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = indexMapping.AddCustomTokenFilter(yaraStopFilter, map[string]interface{}{
+		"type":           stop.Name,
+		"stop_token_map": yaraStopTokenMap,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = indexMapping.AddCustomAnalyzer(yaraAnalyzerName, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     unicode.Name,
+		"token_filters": []string{lowercase.Name, yaraStopFilter},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = indexMapping.AddCustomAnalyzer(lowercaseKeywordAnalyzer, map[string]interface{}{
+		"type":          custom.Name,
+		"tokenizer":     single.Name,
+		"token_filters": []string{lowercase.Name},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	keywordField := bleve.NewTextFieldMapping()
+	keywordField.Analyzer = keyword.Name
+
+	valueField := bleve.NewTextFieldMapping()
+	valueField.Analyzer = lowercaseKeywordAnalyzer
+
+	bodyField := bleve.NewTextFieldMapping()
+	bodyField.Analyzer = yaraAnalyzerName
+
+	dateField := bleve.NewDateTimeFieldMapping()
+	numberField := bleve.NewNumericFieldMapping()
+
+	ruleMapping := bleve.NewDocumentMapping()
+	ruleMapping.AddFieldMappingsAt("id", keywordField)
+	ruleMapping.AddFieldMappingsAt("ruleset", keywordField)
+	ruleMapping.AddFieldMappingsAt("rule", keywordField)
+	ruleMapping.AddFieldMappingsAt("rule_name_tags", keywordField)
+	ruleMapping.AddFieldMappingsAt("rule_tags", keywordField)
+	ruleMapping.AddFieldMappingsAt("user_tags", keywordField)
+	ruleMapping.AddFieldMappingsAt("feed", keywordField)
+	ruleMapping.AddFieldMappingsAt("trust", keywordField)
+	ruleMapping.AddFieldMappingsAt("misp_event", keywordField)
+	ruleMapping.AddFieldMappingsAt("license", keywordField)
+	ruleMapping.AddFieldMappingsAt("license_source", keywordField)
+	ruleMapping.AddFieldMappingsAt("body", bodyField)
+	for _, field := range []string{"modules", "functions", "module_fields", "rule_refs", "condition_features", "min_yara_version", "yara_features", "attack_techniques", "attack_tactics", aliasActor, aliasFamily} {
+		ruleMapping.AddFieldMappingsAt(field, keywordField)
+	}
+	for _, iocType := range iocTypes {
+		ruleMapping.AddFieldMappingsAt("ioc_"+iocType, keywordField)
+	}
+	for _, field := range rangeDateFields {
+		ruleMapping.AddFieldMappingsAt(field, dateField)
+	}
+	ruleMapping.AddFieldMappingsAt("fp_hits", numberField)
+	ruleMapping.AddFieldMappingsAt("quality", numberField)
+	ruleMapping.AddFieldMappingsAt("quality_issues", keywordField)
+	ruleMapping.AddFieldMappingsAt("fp_tested", dateField)
+
+	datesMapping := bleve.NewDocumentMapping()
+	for _, field := range []string{"field", "raw", "date", "confidence"} {
+		datesMapping.AddFieldMappingsAt(field, keywordField)
+	}
+	ruleMapping.AddSubDocumentMapping("dates", datesMapping)
+
+	// String definitions are searched as their own documents
+	ruleMapping.AddSubDocumentMapping("strings", bleve.NewDocumentDisabledMapping())
 
-		mapping := bleve.NewIndexMapping()
-		index, err := bleve.New("example.bleve", mapping)
+	stringMapping := bleve.NewDocumentMapping()
+	for _, field := range []string{"doc_type", "rule_id", "ruleset", "rule", "identifier", "type", "modifiers", "hex"} {
+		stringMapping.AddFieldMappingsAt(field, keywordField)
+	}
+	stringMapping.AddFieldMappingsAt("value", valueField)
+
+	indexMapping.DefaultMapping = ruleMapping
+	indexMapping.AddDocumentMapping(stringDocKind, stringMapping)
+	return indexMapping, nil
+}
+
+// initializeBleve opens the index in the database directory, creating
+// it if it does not exist yet.
+func initializeBleve(ctx *YaramanContext) error {
+	err := os.MkdirAll(ctx.databaseDir, 0755)
+	if err != nil {
+		return err
+	}
+	indexPath := makeFullPath(ctx.databaseDir, indexName)
+	if dirExists(indexPath) {
+		ctx.index, err = bleve.Open(indexPath)
+		return err
+	}
+
+	indexMapping, err := buildIndexMapping()
+	if err != nil {
+		return err
+	}
+	ctx.index, err = bleve.New(indexPath, indexMapping)
+	return err
+}
+
+func closeBleve(ctx *YaramanContext) {
+	if ctx.index == nil {
+		return
+	}
+	err := flushBatch(ctx)
+	if err != nil {
+		errorLogger.Error().AnErr("error", err).Msg("Could not write pending changes to the index.")
+	}
+	err = ctx.index.Close()
+	if err != nil {
+		errorLogger.Error().AnErr("error", err).Msg("Could not close the index.")
+	}
+	ctx.index = nil
+}
+
+// flushBatch writes any batched index operations.
+func flushBatch(ctx *YaramanContext) error {
+	if ctx.batch == nil || ctx.batch.Size() == 0 {
+		return nil
+	}
+	err := ctx.index.Batch(ctx.batch)
+	ctx.batch.Reset()
+	return err
+}
+
+func batchFor(ctx *YaramanContext) (*bleve.Batch, error) {
+	if ctx.batch == nil {
+		ctx.batch = ctx.index.NewBatch()
+	}
+	if ctx.batch.Size() >= maxBatchOperations {
+		err := flushBatch(ctx)
 		if err != nil {
-			fmt.Println(err)
-			return
+			return nil, err
 		}
-	*/
+	}
+	return ctx.batch, nil
 }
 
-func closeBleve() {
+// indexYaraRule adds a rule and its string definitions to the index. The
+// full document is also kept as an internal value since bleve does not
+// return the original source.
+func indexYaraRule(ctx *YaramanContext, doc *yaraRuleType) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	batch, err := batchFor(ctx)
+	if err != nil {
+		return err
+	}
+	previous, err := getRuleDoc(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	previousStrings := 0
+	if previous != nil {
+		previousStrings = len(previous.Strings)
+	}
+	err = batch.Index(doc.ID, doc)
+	if err != nil {
+		return err
+	}
+	err = indexStringDocs(batch, doc, previousStrings)
+	if err != nil {
+		return err
+	}
+	batch.SetInternal([]byte(ruleDocPrefix+doc.ID), data)
+	return nil
+}
 
+// getRuleDoc returns the stored document for a rule ID, or nil if the
+// rule is not in the index.
+func getRuleDoc(ctx *YaramanContext, id string) (*yaraRuleType, error) {
+	data, err := ctx.index.GetInternal([]byte(ruleDocPrefix + id))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	doc := &yaraRuleType{}
+	err = json.Unmarshal(data, doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
 }
 
-func indexYaraRule() {
+// saveInternal stores a JSON encoded value under key in the index.
+func saveInternal(ctx *YaramanContext, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return ctx.index.SetInternal([]byte(key), data)
+}
 
+// loadInternal reads a JSON encoded value stored under key. It returns
+// false if nothing is stored under the key.
+func loadInternal(ctx *YaramanContext, key string, value interface{}) (bool, error) {
+	data, err := ctx.index.GetInternal([]byte(key))
+	if err != nil || data == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, value)
 }
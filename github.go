@@ -2,13 +2,24 @@ package main
 
 import (
 	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
 )
 
-func getGithubRepo(url string, dest string) error {
-	var err error
+// getGithubRepo clones a repository, or pulls it if it was already
+// cloned. If branch is empty the remote's default branch is used.
+func getGithubRepo(url string, dest string, branch string) error {
+	var (
+		err       error
+		reference plumbing.ReferenceName
+	)
 
+	if branch != "" {
+		reference = plumbing.NewBranchReferenceName(branch)
+	}
 	_, err = git.PlainClone(dest, false, &git.CloneOptions{
-		URL: url,
+		URL:           url,
+		ReferenceName: reference,
+		SingleBranch:  branch != "",
 	})
 	// Pull if the repo already exists
 	if err == git.ErrRepositoryAlreadyExists {
@@ -23,10 +34,28 @@ func getGithubRepo(url string, dest string) error {
 		if err != nil {
 			return err
 		}
-		err = worktree.Pull(&git.PullOptions{RemoteName: "origin"})
+		err = worktree.Pull(&git.PullOptions{
+			RemoteName:    "origin",
+			ReferenceName: reference,
+			SingleBranch:  branch != "",
+		})
 		if err == git.NoErrAlreadyUpToDate {
 			err = nil
 		}
 	}
 	return err
 }
+
+// gitHeadCommit returns the commit checked out in the git repository
+// containing path, or "" if path is not in a repository.
+func gitHeadCommit(path string) string {
+	repo, err := git.PlainOpenWithOptions(path, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return ""
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return ""
+	}
+	return head.Hash().String()
+}
@@ -23,11 +23,12 @@ require (
 	github.com/rivo/tview v0.0.0-20200915114512-42866ecf6ca6
 	github.com/rs/zerolog v1.20.0
 	github.com/scylladb/termtables v1.0.0
-	github.com/sergi/go-diff v1.1.0 // indirect
+	github.com/sergi/go-diff v1.1.0
 	github.com/tebeka/snowball v0.4.2 // indirect
 	github.com/tecbot/gorocksdb v0.0.0-20191217155057-f0fad39f321c // indirect
 	github.com/willabides/kongplete v0.1.0
 	go.mongodb.org/mongo-driver v1.4.2
+	golang.org/x/net v0.0.0-20200822124328-c89045814202
 	golang.org/x/tools v0.0.0-20201009162240-fcf82128ed91 // indirect
 	google.golang.org/grpc v1.32.0
 	gopkg.in/src-d/go-git.v4 v4.13.1
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Reference to a rule that is not in any indexed ruleset.
+type danglingRefType struct {
+	ID        string `json:"id"`
+	Ruleset   string `json:"ruleset"`
+	Rule      string `json:"rule"`
+	Reference string `json:"reference"`
+}
+
+type graphNodeType struct {
+	ID      string `json:"id"`
+	Ruleset string `json:"ruleset"`
+	Rule    string `json:"rule"`
+	Private bool   `json:"private"`
+}
+
+type graphEdgeType struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Dependencies between indexed rules, built from their rule_refs.
+type ruleGraphType struct {
+	docs       map[string]*yaraRuleType
+	deps       map[string][]string
+	dependents map[string][]string
+	dangling   []*danglingRefType
+}
+
+// buildRuleGraph resolves the rule references of all rules. A reference
+// resolves to the rule of that name in the same ruleset, or else to the
+// rules of that name in other rulesets, which may be included.
+func buildRuleGraph(docs []*yaraRuleType) *ruleGraphType {
+	graph := &ruleGraphType{
+		docs:       map[string]*yaraRuleType{},
+		deps:       map[string][]string{},
+		dependents: map[string][]string{},
+	}
+	byName := map[string][]*yaraRuleType{}
+	for _, doc := range docs {
+		graph.docs[doc.ID] = doc
+		byName[doc.RuleName] = append(byName[doc.RuleName], doc)
+	}
+	for _, doc := range docs {
+		for _, ref := range doc.RuleRefs {
+			targets := []*yaraRuleType{}
+			for _, candidate := range byName[ref] {
+				if candidate.RulesetName == doc.RulesetName {
+					targets = []*yaraRuleType{candidate}
+					break
+				}
+				targets = append(targets, candidate)
+			}
+			if len(targets) == 0 {
+				graph.dangling = append(graph.dangling, &danglingRefType{
+					ID: doc.ID, Ruleset: doc.RulesetName, Rule: doc.RuleName, Reference: ref,
+				})
+			}
+			for _, target := range targets {
+				graph.deps[doc.ID] = append(graph.deps[doc.ID], target.ID)
+				graph.dependents[target.ID] = append(graph.dependents[target.ID], doc.ID)
+			}
+		}
+	}
+	return graph
+}
+
+// findRules returns the IDs of the rules with the given ID or name.
+func (graph *ruleGraphType) findRules(rule string) []string {
+	if _, ok := graph.docs[rule]; ok {
+		return []string{rule}
+	}
+	ids := []string{}
+	for id, doc := range graph.docs {
+		if doc.RuleName == rule {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// dependencies returns the rules a rule refers to, directly or through
+// other rules, each after the rules it refers to. References to missing
+// rules are errors.
+func (graph *ruleGraphType) dependencies(id string) ([]*yaraRuleType, error) {
+	missing := map[string][]string{}
+	for _, ref := range graph.dangling {
+		missing[ref.ID] = append(missing[ref.ID], ref.Reference)
+	}
+	result := []*yaraRuleType{}
+	seen := MapSet{}
+	var visit func(current string) error
+	visit = func(current string) error {
+		if seen.Contains(current) {
+			return nil
+		}
+		seen.Add(current)
+		if refs := missing[current]; len(refs) > 0 {
+			return fmt.Errorf("rule %s refers to missing rule %s", current, strings.Join(refs, ", "))
+		}
+		deps := append([]string{}, graph.deps[current]...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			err := visit(dep)
+			if err != nil {
+				return err
+			}
+		}
+		if current != id {
+			result = append(result, graph.docs[current])
+		}
+		return nil
+	}
+	err := visit(id)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// reachable returns the rules reachable from start through edges,
+// including start.
+func reachable(start []string, edges map[string][]string) MapSet {
+	seen := MapSet{}
+	pending := append([]string{}, start...)
+	for len(pending) > 0 {
+		id := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen.Contains(id) {
+			continue
+		}
+		seen.Add(id)
+		pending = append(pending, edges[id]...)
+	}
+	return seen
+}
+
+// subgraph restricts the graph to the given rules, their transitive
+// dependencies and their transitive dependents. Without rules, the rules
+// that have dependencies or dependents are kept.
+func (graph *ruleGraphType) subgraph(ids []string) *ruleGraphType {
+	keep := MapSet{}
+	if len(ids) == 0 {
+		for id, deps := range graph.deps {
+			keep.Add(id)
+			keep.AddFromSlice(deps)
+		}
+		for _, ref := range graph.dangling {
+			keep.Add(ref.ID)
+		}
+	} else {
+		keep.AddFrom(reachable(ids, graph.deps))
+		keep.AddFrom(reachable(ids, graph.dependents))
+	}
+	result := &ruleGraphType{
+		docs:       map[string]*yaraRuleType{},
+		deps:       map[string][]string{},
+		dependents: map[string][]string{},
+	}
+	for id := range keep {
+		result.docs[id] = graph.docs[id]
+		for _, dep := range graph.deps[id] {
+			if keep.Contains(dep) {
+				result.deps[id] = append(result.deps[id], dep)
+				result.dependents[dep] = append(result.dependents[dep], id)
+			}
+		}
+	}
+	for _, ref := range graph.dangling {
+		if keep.Contains(ref.ID) {
+			result.dangling = append(result.dangling, ref)
+		}
+	}
+	return result
+}
+
+func (graph *ruleGraphType) nodes() []*graphNodeType {
+	nodes := []*graphNodeType{}
+	for _, doc := range graph.docs {
+		nodes = append(nodes, &graphNodeType{ID: doc.ID, Ruleset: doc.RulesetName, Rule: doc.RuleName, Private: doc.Private})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].Ruleset != nodes[j].Ruleset {
+			return nodes[i].Ruleset < nodes[j].Ruleset
+		}
+		return nodes[i].Rule < nodes[j].Rule
+	})
+	return nodes
+}
+
+func (graph *ruleGraphType) edges() []*graphEdgeType {
+	edges := []*graphEdgeType{}
+	for _, node := range graph.nodes() {
+		deps := append([]string{}, graph.deps[node.ID]...)
+		sort.Strings(deps)
+		for _, dep := range deps {
+			edges = append(edges, &graphEdgeType{From: node.ID, To: dep})
+		}
+	}
+	return edges
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// writeGraphDOT writes the graph in Graphviz DOT format. Private rules
+// are dashed, dangling references are red.
+func writeGraphDOT(out io.Writer, graph *ruleGraphType) {
+	fmt.Fprintln(out, "digraph rules {")
+	fmt.Fprintln(out, "  node [shape=box];")
+	for _, node := range graph.nodes() {
+		style := ""
+		if node.Private {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(out, "  %s [label=%s%s];\n", dotQuote(node.ID), dotQuote(node.Rule+"\n"+node.Ruleset), style)
+	}
+	for _, edge := range graph.edges() {
+		fmt.Fprintf(out, "  %s -> %s;\n", dotQuote(edge.From), dotQuote(edge.To))
+	}
+	for _, ref := range graph.dangling {
+		missing := dotQuote("missing:" + ref.Reference)
+		fmt.Fprintf(out, "  %s [label=%s, color=red, fontcolor=red];\n", missing, dotQuote(ref.Reference))
+		fmt.Fprintf(out, "  %s -> %s [color=red];\n", dotQuote(ref.ID), missing)
+	}
+	fmt.Fprintln(out, "}")
+}
+
+func writeGraphJSON(out io.Writer, graph *ruleGraphType) error {
+	dangling := graph.dangling
+	if dangling == nil {
+		dangling = []*danglingRefType{}
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Nodes    []*graphNodeType   `json:"nodes"`
+		Edges    []*graphEdgeType   `json:"edges"`
+		Dangling []*danglingRefType `json:"dangling"`
+	}{graph.nodes(), graph.edges(), dangling})
+}
+
+func writeDanglingRefs(out io.Writer, refs []*danglingRefType) {
+	for _, ref := range refs {
+		fmt.Fprintf(out, "%s %s:%s refers to missing rule %s\n", ref.ID, ref.Ruleset, ref.Rule, ref.Reference)
+	}
+}
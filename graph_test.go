@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestRuleDependencies(t *testing.T) {
+	docs := []*yaraRuleType{
+		{ID: "top", RulesetName: "a", RuleName: "top", RuleRefs: []string{"mid", "base"}},
+		{ID: "mid", RulesetName: "a", RuleName: "mid", RuleRefs: []string{"base"}},
+		{ID: "base", RulesetName: "a", RuleName: "base"},
+		{ID: "other", RulesetName: "b", RuleName: "base"},
+		{ID: "lonely", RulesetName: "c", RuleName: "lonely", RuleRefs: []string{"ghost"}},
+	}
+	graph := buildRuleGraph(docs)
+	deps, err := graph.dependencies("top")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := []string{}
+	for _, dep := range deps {
+		ids = append(ids, dep.ID)
+	}
+	if len(ids) != 2 || ids[0] != "base" || ids[1] != "mid" {
+		t.Errorf("expected [base mid], found %v", ids)
+	}
+	_, err = graph.dependencies("lonely")
+	if err == nil {
+		t.Error("expected an error for a missing rule")
+	}
+
+	exported := withDependencies([]*yaraRuleType{docs[0], docs[2]}, map[string][]*yaraRuleType{"top": deps})
+	if len(exported) != 3 || exported[2].ID != "top" {
+		t.Errorf("dependencies not added once before top: %v", exported)
+	}
+}
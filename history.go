@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// Internal keys holding the rule IDs of a ruleset and the rulesets
+	// imported from a source.
+	rulesetRulesKey   = "ruleset:%s"
+	sourceRulesetsKey = "source:%s:%s"
+)
+
+// loadList returns the string list stored under key, or nil if there
+// is none.
+func loadList(ctx *YaramanContext, key string) ([]string, error) {
+	var list []string
+	_, err := loadInternal(ctx, key, &list)
+	return list, err
+}
+
+// setList writes a string list through the current batch. An empty list
+// removes the key.
+func setList(ctx *YaramanContext, key string, list []string) error {
+	batch, err := batchFor(ctx)
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		batch.DeleteInternal([]byte(key))
+		return nil
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	batch.SetInternal([]byte(key), data)
+	return nil
+}
+
+// deleteYaraRule removes a rule, its string definitions and its stored
+// document from the index.
+func deleteYaraRule(ctx *YaramanContext, id string) error {
+	batch, err := batchFor(ctx)
+	if err != nil {
+		return err
+	}
+	previous, err := getRuleDoc(ctx, id)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		for i := range previous.Strings {
+			batch.Delete(stringDocID(id, i))
+		}
+	}
+	batch.Delete(id)
+	batch.DeleteInternal([]byte(ruleDocPrefix + id))
+	return nil
+}
+
+// addRuleDoc indexes a rule, recording it as added or changed in the
+// import in progress.
+func addRuleDoc(ctx *YaramanContext, doc *yaraRuleType) error {
+	if ctx.report != nil {
+		err := ctx.report.recordRule(ctx, doc)
+		if err != nil {
+			return err
+		}
+	}
+	return indexYaraRule(ctx, doc)
+}
+
+// trackList loads the list stored under key and remembers its value
+// before the import so it can be restored by a rollback.
+func (report *importReportType) trackList(ctx *YaramanContext, key string) ([]string, error) {
+	list, err := loadList(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := report.undo.Lists[key]; !ok {
+		report.undo.Lists[key] = list
+	}
+	return list, nil
+}
+
+func (report *importReportType) recordRule(ctx *YaramanContext, doc *yaraRuleType) error {
+	report.seen[doc.RulesetName] = append(report.seen[doc.RulesetName], doc.ID)
+
+	previous, err := getRuleDoc(ctx, doc.ID)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		report.Added = append(report.Added, doc.ID)
+		return nil
+	}
+	if previous.Imported != "" {
+		doc.Imported = previous.Imported
+	}
+	// Keep the date from the git history until it is indexed again
+	if doc.LastChanged == "" {
+		doc.LastChanged = previous.LastChanged
+	}
+	// False positive results only hold for an unchanged rule
+	if previous.Body == doc.Body {
+		doc.FPHits = previous.FPHits
+		doc.FPTested = previous.FPTested
+	} else {
+		report.Changed = append(report.Changed, doc.ID)
+		report.undo.Rules = append(report.undo.Rules, previous)
+	}
+	return nil
+}
+
+// keepPrevious records a rule as it was before the import changed it, if
+// it is not already recorded. Rules added by the import are removed by a
+// rollback instead.
+func (report *importReportType) keepPrevious(doc *yaraRuleType) {
+	if containsString(report.Added, doc.ID) {
+		return
+	}
+	for _, previous := range report.undo.Rules {
+		if previous.ID == doc.ID {
+			return
+		}
+	}
+	previous := *doc
+	report.undo.Rules = append(report.undo.Rules, &previous)
+}
+
+func (report *importReportType) removeRule(ctx *YaramanContext, id string) error {
+	previous, err := getRuleDoc(ctx, id)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		return nil
+	}
+	report.Removed = append(report.Removed, id)
+	report.undo.Rules = append(report.undo.Rules, previous)
+	return deleteYaraRule(ctx, id)
+}
+
+// finishRuleset removes rules that are no longer in a successfully
+// parsed ruleset.
+func (report *importReportType) finishRuleset(ctx *YaramanContext, rulesetName string) error {
+	key := fmt.Sprintf(rulesetRulesKey, rulesetName)
+	previousIDs, err := report.trackList(ctx, key)
+	if err != nil {
+		return err
+	}
+	current := MapSet{}
+	current.AddFromSlice(report.seen[rulesetName])
+	for _, id := range previousIDs {
+		if current.Contains(id) {
+			continue
+		}
+		err = report.removeRule(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+	return setList(ctx, key, report.seen[rulesetName])
+}
+
+// finishSource removes the rules of rulesets that were previously
+// imported from the same source but no longer exist there. Rulesets that
+// failed to parse keep their previous rules.
+func (report *importReportType) finishSource(ctx *YaramanContext) error {
+	key := fmt.Sprintf(sourceRulesetsKey, report.SourceType, report.Source)
+	previousRulesets, err := report.trackList(ctx, key)
+	if err != nil {
+		return err
+	}
+	current := MapSet{}
+	rulesets := []string{}
+	for _, file := range report.Files {
+		current.Add(file.Filename)
+		rulesets = append(rulesets, file.Filename)
+	}
+	for _, rulesetName := range previousRulesets {
+		if current.Contains(rulesetName) {
+			continue
+		}
+		err = report.finishRuleset(ctx, rulesetName)
+		if err != nil {
+			return err
+		}
+	}
+	return setList(ctx, key, rulesets)
+}
+
+// rollbackImport restores the index to its state before the import.
+func rollbackImport(ctx *YaramanContext, report *importReportType) error {
+	undo := &importUndoType{}
+	found, err := loadInternal(ctx, fmt.Sprintf(importUndoKey, report.ID), undo)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no rollback information for import %d", report.ID)
+	}
+
+	for _, id := range report.Added {
+		err = deleteYaraRule(ctx, id)
+		if err != nil {
+			return err
+		}
+	}
+	for _, doc := range undo.Rules {
+		err = indexYaraRule(ctx, doc)
+		if err != nil {
+			return err
+		}
+	}
+	for key, list := range undo.Lists {
+		err = setList(ctx, key, list)
+		if err != nil {
+			return err
+		}
+	}
+	for id, versions := range undo.Versions {
+		err = restoreRuleVersions(ctx, id, versions)
+		if err != nil {
+			return err
+		}
+	}
+	err = flushBatch(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	report.RolledBack = &now
+	return saveImportReport(ctx, report)
+}
+
+// rollbackImports undoes every import from the given one onwards, newest
+// first. Imports that were already rolled back are skipped.
+func rollbackImports(ctx *YaramanContext, id int) ([]*importReportType, error) {
+	reports, err := loadImportReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = loadImportReport(ctx, id); err != nil {
+		return nil, err
+	}
+
+	rolledBack := []*importReportType{}
+	for i := len(reports) - 1; i >= 0; i-- {
+		report := reports[i]
+		if report.ID < id {
+			break
+		}
+		if report.RolledBack != nil {
+			continue
+		}
+		err = rollbackImport(ctx, report)
+		if err != nil {
+			return rolledBack, err
+		}
+		logger.Info().Int("import", report.ID).Msg("Import rolled back")
+		rolledBack = append(rolledBack, report)
+	}
+	return rolledBack, nil
+}
+
+// importSnapshot returns the rules that were in the index after the given
+// import by undoing the later imports in memory. Import 0 is the empty
+// index.
+func importSnapshot(ctx *YaramanContext, id int) (map[string]*yaraRuleType, error) {
+	docs, err := searchRules(ctx, buildQuery(""))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := map[string]*yaraRuleType{}
+	for _, doc := range docs {
+		snapshot[doc.ID] = doc
+	}
+
+	reports, err := loadImportReports(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(reports) - 1; i >= 0 && reports[i].ID > id; i-- {
+		report := reports[i]
+		if report.RolledBack != nil {
+			continue
+		}
+		undo := &importUndoType{}
+		found, err := loadInternal(ctx, fmt.Sprintf(importUndoKey, report.ID), undo)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, fmt.Errorf("no rollback information for import %d", report.ID)
+		}
+		for _, added := range report.Added {
+			delete(snapshot, added)
+		}
+		for _, doc := range undo.Rules {
+			snapshot[doc.ID] = doc
+		}
+	}
+	return snapshot, nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/VirusTotal/gyp/ast"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Indicator types, indexed as ioc_<type> fields.
+const (
+	iocDomain   = "domain"
+	iocIP       = "ip"
+	iocURL      = "url"
+	iocHash     = "hash"
+	iocMutex    = "mutex"
+	iocPDB      = "pdb"
+	iocRegistry = "registry"
+)
+
+var (
+	iocTypes = []string{iocDomain, iocIP, iocURL, iocHash, iocMutex, iocPDB, iocRegistry}
+
+	urlRE      = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s"'<>\x00]+`)
+	domainRE   = regexp.MustCompile(`(?i)\b(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,24}\b`)
+	ipRE       = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+	pdbRE      = regexp.MustCompile(`(?i)(?:\b[a-z]:\\|\\\\[^\\\s"]+\\)[^\x00-\x1f"*<>|:?]*?\.pdb\b`)
+	mutexRE    = regexp.MustCompile(`(?i)^(?:Global|Local|Session\\\d+)\\[^\\]+$`)
+	registryRE = regexp.MustCompile(`(?i)^(?:HKEY_[A-Z_]+|HKLM|HKCU|HKCR|HKU|HKCC)(?:\\|$)|^(?:SOFTWARE|SYSTEM)\\(?:Microsoft|CurrentControlSet|Classes|Policies|Wow6432Node)\\`)
+
+	// Endings of file names that look like domains, like kernel32.dll
+	fileNameEndings = MapSet{}
+
+	// Metadata that describes the rule rather than the threat
+	nonIOCMetaKeys = MapSet{"reference": true, "author": true, "license": true, "version": true}
+
+	// Metadata whose numbers are more likely versions than IP addresses
+	nonIPMetaKeys = MapSet{"description": true}
+
+	// First labels of .NET and Java names that look like domains, like
+	// System.Net.WebClient and com.example.app
+	namespaceRoots = MapSet{"system": true, "microsoft": true, "java": true, "javax": true, "android": true, "dalvik": true, "kotlin": true, "com": true, "org": true, "net": true}
+)
+
+func init() {
+	for _, extension := range strings.Split("bat,bin,cmd,cpl,dat,dll,doc,docx,drv,exe,gif,htm,html,ini,jar,jpg,js,json,lnk,log,msi,ocx,pdb,pdf,php,png,ps1,py,rar,scr,sh,so,sys,tmp,txt,vbs,xls,xlsx,xml,zip", ",") {
+		fileNameEndings.Add(extension)
+	}
+}
+
+// refang undoes common defanging of indicators in meta values, like
+// hxxp and example[.]com.
+func refang(s string) string {
+	replacer := strings.NewReplacer("[.]", ".", "(.)", ".", "{.}", ".", "[dot]", ".", "[:]", ":", "hxxp", "http", "hXXp", "http")
+	return replacer.Replace(s)
+}
+
+// Indicators found in a rule, by type.
+type iocSetType map[string][]string
+
+func (iocs iocSetType) add(iocType string, value string) {
+	if value != "" && !containsString(iocs[iocType], value) {
+		iocs[iocType] = append(iocs[iocType], value)
+	}
+}
+
+func (iocs iocSetType) addDomain(domain string) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	parts := strings.Split(domain, ".")
+	tld := parts[len(parts)-1]
+	if fileNameEndings.Contains(tld) || namespaceRoots.Contains(parts[0]) {
+		return
+	}
+	// Top level domains that are not in the public suffix list are
+	// rather members, like WebClient.DownloadString
+	if _, icann := publicsuffix.PublicSuffix(tld); !icann {
+		return
+	}
+	iocs.add(iocDomain, domain)
+}
+
+func (iocs iocSetType) addIP(ip string) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil || parsed.IsUnspecified() {
+		return
+	}
+	iocs.add(iocIP, parsed.String())
+}
+
+// scanText adds the network indicators, PDB paths, mutexes and registry
+// keys found in a string. IP addresses outside of URLs are only added if
+// withIPs is set.
+func (iocs iocSetType) scanText(text string, withIPs bool) {
+	for _, rawURL := range urlRE.FindAllString(text, -1) {
+		rawURL = strings.TrimRight(rawURL, ".,;)]")
+		iocs.add(iocURL, rawURL)
+		parsed, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+		if net.ParseIP(parsed.Hostname()) != nil {
+			iocs.addIP(parsed.Hostname())
+		} else {
+			iocs.addDomain(parsed.Hostname())
+		}
+	}
+	withoutURLs := urlRE.ReplaceAllString(text, " ")
+	for _, ip := range ipRE.FindAllString(withoutURLs, -1) {
+		if withIPs {
+			iocs.addIP(ip)
+		}
+	}
+	for _, domain := range domainRE.FindAllString(ipRE.ReplaceAllString(withoutURLs, " "), -1) {
+		iocs.addDomain(domain)
+	}
+	for _, pdb := range pdbRE.FindAllString(text, -1) {
+		iocs.add(iocPDB, strings.TrimSpace(pdb))
+	}
+	if mutexRE.MatchString(text) {
+		iocs.add(iocMutex, text)
+	}
+	if registryRE.MatchString(text) {
+		iocs.add(iocRegistry, text)
+	}
+}
+
+// extractIOCs finds indicators in the text strings and metadata of a rule.
+// Hashes are only taken from hash metadata, and references and authors
+// are skipped.
+func extractIOCs(rule *ast.Rule, metadata map[string][]string) iocSetType {
+	iocs := iocSetType{}
+	for _, s := range rule.Strings {
+		text, ok := s.(*ast.TextString)
+		if !ok {
+			continue
+		}
+		iocs.scanText(text.UnescapedValue(), true)
+	}
+	for key, values := range metadata {
+		if nonIOCMetaKeys.Contains(key) {
+			continue
+		}
+		for _, value := range values {
+			if key == "hash" {
+				for _, hash := range sampleHashRE.FindAllString(value, -1) {
+					iocs.add(iocHash, strings.ToLower(hash))
+				}
+				continue
+			}
+			iocs.scanText(refang(value), !nonIPMetaKeys.Contains(key))
+		}
+	}
+	for _, values := range iocs {
+		sort.Strings(values)
+	}
+	return iocs
+}
+
+// ruleIOCs returns the indicators of an indexed rule by type.
+func ruleIOCs(doc *yaraRuleType) iocSetType {
+	return iocSetType{
+		iocDomain:   doc.IOCDomain,
+		iocIP:       doc.IOCIP,
+		iocURL:      doc.IOCURL,
+		iocHash:     doc.IOCHash,
+		iocMutex:    doc.IOCMutex,
+		iocPDB:      doc.IOCPDB,
+		iocRegistry: doc.IOCRegistry,
+	}
+}
+
+func setRuleIOCs(doc *yaraRuleType, iocs iocSetType) {
+	doc.IOCDomain = iocs[iocDomain]
+	doc.IOCIP = iocs[iocIP]
+	doc.IOCURL = iocs[iocURL]
+	doc.IOCHash = iocs[iocHash]
+	doc.IOCMutex = iocs[iocMutex]
+	doc.IOCPDB = iocs[iocPDB]
+	doc.IOCRegistry = iocs[iocRegistry]
+}
+
+// writeIOCs writes the indicators of rules, one per line in text and CSV
+// and one object per rule in JSON.
+func writeIOCs(out io.Writer, format string, docs []*yaraRuleType) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(out)
+		for _, doc := range docs {
+			iocs := ruleIOCs(doc)
+			for iocType, values := range iocs {
+				if len(values) == 0 {
+					delete(iocs, iocType)
+				}
+			}
+			if len(iocs) == 0 {
+				continue
+			}
+			err := encoder.Encode(struct {
+				ID      string     `json:"id"`
+				Ruleset string     `json:"ruleset"`
+				Rule    string     `json:"rule"`
+				IOCs    iocSetType `json:"iocs"`
+			}{doc.ID, doc.RulesetName, doc.RuleName, iocs})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "csv":
+		writer := csv.NewWriter(out)
+		err := writer.Write([]string{"id", "ruleset", "rule", "type", "value"})
+		if err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			iocs := ruleIOCs(doc)
+			for _, iocType := range iocTypes {
+				for _, value := range iocs[iocType] {
+					err = writer.Write([]string{doc.ID, csvCell(doc.RulesetName), csvCell(doc.RuleName), iocType, csvCell(value)})
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	for _, doc := range docs {
+		iocs := ruleIOCs(doc)
+		for _, iocType := range iocTypes {
+			for _, value := range iocs[iocType] {
+				fmt.Fprintf(out, "%s %-8s %s\n", doc.ID, iocType, value)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractIOCs(t *testing.T) {
+	rule, err := parseRuleBody(`rule test {
+  meta:
+    description = "Loader from 2020, version 1.2.3.4 contacts evil.example.com"
+    version = "2.0.1.5"
+    pdb = "Built from C:\\Users\\dev\\loader.pdb on the build server"
+  strings:
+    $a = "System.Net.WebClient"
+    $b = "http://10.1.2.3/gate.php"
+    $c = "update.microsoft-cdn.io"
+    $d = "\\\\fileserver\\builds\\x64\\stage2.pdb"
+    $e = "kernel32.dll"
+    $f = "WebClient.DownloadString"
+    $g = "java.lang.Runtime"
+  condition:
+    any of them
+}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := map[string][]string{}
+	for _, meta := range rule.Meta {
+		metadata[meta.Key] = append(metadata[meta.Key], metaValue(meta))
+	}
+	iocs := extractIOCs(rule, metadata)
+	expected := iocSetType{
+		iocDomain: {"evil.example.com", "update.microsoft-cdn.io"},
+		iocIP:     {"10.1.2.3"},
+		iocURL:    {"http://10.1.2.3/gate.php"},
+		iocPDB:    {`C:\Users\dev\loader.pdb`, `\\fileserver\builds\x64\stage2.pdb`},
+	}
+	if !reflect.DeepEqual(iocs, expected) {
+		t.Errorf("expected %v, found %v", expected, iocs)
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// Where the license of a rule was found
+const (
+	licenseFromMeta = "meta"
+	licenseFromSPDX = "spdx"
+	licenseFromFile = "file"
+)
+
+// Actions of export on a license policy violation
+const (
+	licenseRefuse = "refuse"
+	licenseWarn   = "warn"
+)
+
+// License of a ruleset or rule, identified by its SPDX identifier when it
+// is known.
+type licenseType struct {
+	ID     string
+	Source string
+}
+
+// Distribution policy of exports, set with a [license_policy] section in
+// yaraman.toml like
+//
+//	[license_policy]
+//	allowed = ["MIT", "Apache-2.0", "DRL-1.1", "CC-BY-4.0"]
+//	commercial = true
+//	allow_unknown = false
+//	action = "refuse"
+//
+// Without a policy, export only warns about licenses that cannot be mixed.
+type licensePolicyType struct {
+	// Licenses rules may be distributed under, any if empty
+	Allowed []string `toml:"allowed"`
+	// Exports are distributed commercially, which non-commercial licenses
+	// forbid
+	Commercial bool `toml:"commercial"`
+	// Rules without a known license may be exported
+	AllowUnknown bool `toml:"allow_unknown"`
+	// refuse or warn, refuse if empty
+	Action string `toml:"action"`
+}
+
+// Licenses that cannot be distributed together in one file, with the
+// reason
+type licenseConflictType struct {
+	A, B   string
+	Reason string
+}
+
+var (
+	spdxRE = regexp.MustCompile(`SPDX-License-Identifier:\s*([A-Za-z0-9.+-]+)`)
+
+	licensePolicy = defaultLicensePolicy()
+
+	// SPDX identifiers by their licenseKey and common spellings
+	licenseNames = map[string]string{}
+
+	knownLicenses = []string{
+		"Apache-2.0", "BSD-2-Clause", "BSD-3-Clause", "CC0-1.0", "CC-BY-4.0", "CC-BY-SA-4.0",
+		"CC-BY-NC-4.0", "CC-BY-NC-SA-4.0", "CC-BY-NC-ND-4.0", "DRL-1.0", "DRL-1.1",
+		"GPL-2.0-only", "GPL-2.0-or-later", "GPL-3.0-only", "GPL-3.0-or-later",
+		"LGPL-2.1-only", "LGPL-3.0-only", "MIT", "MPL-2.0",
+	}
+
+	licenseAliases = map[string]string{
+		"apache2":                "Apache-2.0",
+		"apachelicense20":        "Apache-2.0",
+		"apachelicenseversion20": "Apache-2.0",
+		"bsd2clause":             "BSD-2-Clause",
+		"bsd3clause":             "BSD-3-Clause",
+		"bsd":                    "BSD-3-Clause",
+		"cc0":                    "CC0-1.0",
+		"ccby":                   "CC-BY-4.0",
+		"ccbysa":                 "CC-BY-SA-4.0",
+		"ccbync":                 "CC-BY-NC-4.0",
+		"ccbyncsa":               "CC-BY-NC-SA-4.0",
+		"ccbyncnd":               "CC-BY-NC-ND-4.0",
+		"drl":                    "DRL-1.1",
+		"drl11":                  "DRL-1.1",
+		"detectionrulelicense":   "DRL-1.1",
+		"detectionrulelicense11": "DRL-1.1",
+		"detectionrulelicense10": "DRL-1.0",
+		"gpl2":                   "GPL-2.0-only",
+		"gplv2":                  "GPL-2.0-only",
+		"gpl20":                  "GPL-2.0-only",
+		"gpl3":                   "GPL-3.0-only",
+		"gplv3":                  "GPL-3.0-only",
+		"gpl30":                  "GPL-3.0-only",
+		"gpl20+":                 "GPL-2.0-or-later",
+		"gplv2+":                 "GPL-2.0-or-later",
+		"gpl30+":                 "GPL-3.0-or-later",
+		"gplv3+":                 "GPL-3.0-or-later",
+		"mitlicense":             "MIT",
+		"mozillapubliclicense20": "MPL-2.0",
+	}
+
+	// License texts are recognized by these phrases, most specific first.
+	// Licenses mention each other in their body, like MPL-2.0 naming the
+	// LGPL, so licenses that have a title are recognized by it.
+	licenseTexts = []struct {
+		ID string
+		// Phrases of the title, in the first lines of the text
+		Title []string
+		// Phrases anywhere in the text
+		Phrases []string
+	}{
+		{"DRL-1.1", []string{"detection rule license", "1.1"}, nil},
+		{"DRL-1.0", []string{"detection rule license"}, nil},
+		{"GPL-3.0-only", []string{"gnu general public license", "version 3"}, nil},
+		{"GPL-2.0-only", []string{"gnu general public license", "version 2"}, nil},
+		{"LGPL-3.0-only", []string{"gnu lesser general public license", "version 3"}, nil},
+		{"LGPL-2.1-only", []string{"gnu lesser general public license", "version 2.1"}, nil},
+		{"Apache-2.0", []string{"apache license", "version 2.0"}, nil},
+		{"MPL-2.0", []string{"mozilla public license", "2.0"}, nil},
+		{"CC-BY-NC-SA-4.0", nil, []string{"attribution-noncommercial-sharealike 4.0"}},
+		{"CC-BY-NC-ND-4.0", nil, []string{"attribution-noncommercial-noderivatives 4.0"}},
+		{"CC-BY-NC-4.0", nil, []string{"attribution-noncommercial 4.0"}},
+		{"CC-BY-SA-4.0", nil, []string{"attribution-sharealike 4.0"}},
+		{"CC-BY-4.0", nil, []string{"attribution 4.0 international"}},
+		{"CC0-1.0", []string{"cc0 1.0"}, nil},
+		{"MIT", nil, []string{"permission is hereby granted, free of charge"}},
+		{"BSD-3-Clause", nil, []string{"redistribution and use in source and binary forms", "neither the name"}},
+		{"BSD-2-Clause", nil, []string{"redistribution and use in source and binary forms"}},
+	}
+
+	// Number of non-empty lines searched for the title of a license
+	licenseTitleLines = 4
+
+	licenseFileNames = []string{"LICENSE", "LICENSE.txt", "LICENSE.md", "LICENCE", "COPYING", "COPYING.txt"}
+
+	// License found for each directory, nil if there is none
+	directoryLicenses = map[string]*licenseType{}
+
+	nonCommercialLicenses = MapSet{"CC-BY-NC-4.0": true, "CC-BY-NC-SA-4.0": true, "CC-BY-NC-ND-4.0": true}
+
+	gplLicenses = []string{"GPL-2.0-only", "GPL-2.0-or-later", "GPL-3.0-only", "GPL-3.0-or-later"}
+
+	licenseConflicts = []*licenseConflictType{
+		{"GPL-2.0-only", "GPL-3.0-only", "GPL-2.0 only code cannot be relicensed under GPL-3.0"},
+		{"GPL-2.0-only", "GPL-3.0-or-later", "GPL-2.0 only code cannot be relicensed under GPL-3.0"},
+		{"GPL-2.0-only", "Apache-2.0", "the patent terms of Apache-2.0 are additional restrictions under GPL-2.0"},
+		{"GPL-2.0-only", "LGPL-3.0-only", "LGPL-3.0 code cannot be combined with GPL-2.0 only code"},
+		{"CC-BY-SA-4.0", "CC-BY-NC-SA-4.0", "both licenses require derived works to keep them"},
+	}
+)
+
+func init() {
+	for _, id := range knownLicenses {
+		licenseNames[licenseKey(id)] = id
+	}
+	for alias, id := range licenseAliases {
+		licenseNames[alias] = id
+	}
+	// GPL code cannot be distributed with more restrictions than the GPL
+	for _, gpl := range gplLicenses {
+		for id := range nonCommercialLicenses {
+			licenseConflicts = append(licenseConflicts, &licenseConflictType{gpl, id, "the GPL does not allow the non-commercial restriction"})
+		}
+	}
+}
+
+// licenseKey ignores case, spaces and punctuation other than +, so that
+// GPLv3, gpl-3 and GPL 3 are the same license.
+func licenseKey(name string) string {
+	var key strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '+' {
+			key.WriteRune(r)
+		}
+	}
+	return key.String()
+}
+
+// normalizeLicense returns the SPDX identifier of a license name. Names
+// that are not known are kept as they are.
+func normalizeLicense(name string) string {
+	name = strings.TrimSpace(name)
+	if id, ok := licenseNames[licenseKey(name)]; ok {
+		return id
+	}
+	return name
+}
+
+// identifyLicenseText returns the SPDX identifier of a license text, an
+// empty string if it is not recognized.
+func identifyLicenseText(text string) string {
+	if match := spdxRE.FindStringSubmatch(text); match != nil {
+		return normalizeLicense(match[1])
+	}
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" && len(lines) < licenseTitleLines {
+			lines = append(lines, line)
+		}
+	}
+	title := normalizeLicenseText(strings.Join(lines, " "))
+	text = normalizeLicenseText(text)
+	for _, license := range licenseTexts {
+		if containsPhrases(title, license.Title) && containsPhrases(text, license.Phrases) {
+			return license.ID
+		}
+	}
+	return ""
+}
+
+func normalizeLicenseText(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+func containsPhrases(text string, phrases []string) bool {
+	for _, phrase := range phrases {
+		if !strings.Contains(text, phrase) {
+			return false
+		}
+	}
+	return true
+}
+
+// clearDirectoryLicenses forgets the licenses found so far, as license files
+// may have changed since the last import.
+func clearDirectoryLicenses() {
+	directoryLicenses = map[string]*licenseType{}
+}
+
+// directoryLicense looks for a license file in dir and its parents, up to
+// the root of the git repository or the rules directory.
+func directoryLicense(ctx *YaramanContext, dir string) *licenseType {
+	if license, ok := directoryLicenses[dir]; ok {
+		return license
+	}
+	var license *licenseType
+	for _, name := range licenseFileNames {
+		filename := filepath.Join(dir, name)
+		if !fileExists(filename) {
+			continue
+		}
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			errorLogger.Error().AnErr("error", err).Str("filename", filename).Msg("Could not read license file.")
+			continue
+		}
+		if id := identifyLicenseText(string(data)); id != "" {
+			license = &licenseType{id, licenseFromFile + ":" + filename}
+			break
+		}
+	}
+	parent := filepath.Dir(dir)
+	rulesDir, _ := filepath.Abs(ctx.rulesDir)
+	if license == nil && parent != dir && dir != rulesDir && !dirExists(filepath.Join(dir, ".git")) {
+		license = directoryLicense(ctx, parent)
+	}
+	directoryLicenses[dir] = license
+	return license
+}
+
+// rulesetLicense returns the license of a ruleset from an SPDX identifier
+// in its text or a license file next to it, nil if there is none.
+func rulesetLicense(ctx *YaramanContext, rulesetName string, text string) *licenseType {
+	if match := spdxRE.FindStringSubmatch(text); match != nil {
+		return &licenseType{normalizeLicense(match[1]), licenseFromSPDX}
+	}
+	if strings.Contains(rulesetName, "://") {
+		return nil
+	}
+	dir, err := filepath.Abs(filepath.Dir(rulesetName))
+	if err != nil || !dirExists(dir) {
+		return nil
+	}
+	return directoryLicense(ctx, dir)
+}
+
+// setRuleLicense sets the license of a rule from its metadata, falling
+// back to the license of its ruleset. A license key wins over other keys
+// mentioning a license, like license_url, which are tried in order.
+func setRuleLicense(doc *yaraRuleType, ruleset *licenseType) {
+	keys := []string{}
+	for key := range doc.Metadata {
+		if (strings.Contains(key, "license") || strings.Contains(key, "licence")) && key != "license" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range append([]string{"license"}, keys...) {
+		values := doc.Metadata[key]
+		if len(values) > 0 && strings.TrimSpace(values[0]) != "" {
+			doc.License = normalizeLicense(values[0])
+			doc.LicenseSource = licenseFromMeta
+			return
+		}
+	}
+	if ruleset != nil {
+		doc.License = ruleset.ID
+		doc.LicenseSource = ruleset.Source
+	}
+}
+
+func defaultLicensePolicy() *licensePolicyType {
+	return &licensePolicyType{AllowUnknown: true, Action: licenseWarn}
+}
+
+// loadLicensePolicy reads the [license_policy] section of the
+// configuration.
+func loadLicensePolicy(config *toml.Tree) (*licensePolicyType, error) {
+	tree, ok := config.Get("license_policy").(*toml.Tree)
+	if !ok {
+		if config.Has("license_policy") {
+			return nil, fmt.Errorf("license_policy must be a table")
+		}
+		return defaultLicensePolicy(), nil
+	}
+	for _, key := range tree.Keys() {
+		switch key {
+		case "allowed", "commercial", "allow_unknown", "action":
+		default:
+			return nil, fmt.Errorf("unknown license policy setting %s", key)
+		}
+	}
+	policy := &licensePolicyType{}
+	err := tree.Unmarshal(policy)
+	if err != nil {
+		return nil, err
+	}
+	switch policy.Action {
+	case "":
+		policy.Action = licenseRefuse
+	case licenseRefuse, licenseWarn:
+	default:
+		return nil, fmt.Errorf("unknown license policy action %q, expected refuse or warn", policy.Action)
+	}
+	for i, license := range policy.Allowed {
+		policy.Allowed[i] = normalizeLicense(license)
+	}
+	return policy, nil
+}
+
+// licenseViolations returns the reasons the rules cannot be exported
+// together under the policy.
+func licenseViolations(docs []*yaraRuleType, policy *licensePolicyType) []string {
+	rulesByLicense := map[string][]string{}
+	for _, doc := range docs {
+		rulesByLicense[doc.License] = append(rulesByLicense[doc.License], doc.RulesetName+":"+doc.RuleName)
+	}
+	violations := []string{}
+	for _, license := range sortedLicenses(rulesByLicense) {
+		rules := rulesByLicense[license]
+		switch {
+		case license == "":
+			if !policy.AllowUnknown {
+				violations = append(violations, fmt.Sprintf("no known license (rules: %d, like %s)", len(rules), rules[0]))
+			}
+		case len(policy.Allowed) > 0 && !containsString(policy.Allowed, license):
+			violations = append(violations, fmt.Sprintf("%s is not an allowed license (rules: %d, like %s)", license, len(rules), rules[0]))
+		case policy.Commercial && nonCommercialLicenses.Contains(license):
+			violations = append(violations, fmt.Sprintf("%s forbids commercial distribution (rules: %d, like %s)", license, len(rules), rules[0]))
+		}
+	}
+	for _, conflict := range licenseConflicts {
+		if len(rulesByLicense[conflict.A]) > 0 && len(rulesByLicense[conflict.B]) > 0 {
+			violations = append(violations, fmt.Sprintf("%s and %s cannot be mixed, %s (%s and %s)",
+				conflict.A, conflict.B, conflict.Reason, rulesByLicense[conflict.A][0], rulesByLicense[conflict.B][0]))
+		}
+	}
+	return violations
+}
+
+// checkLicensePolicy lists the license violations of an export on report
+// and returns an error if the policy refuses them.
+func checkLicensePolicy(docs []*yaraRuleType, policy *licensePolicyType, report io.Writer) error {
+	violations := licenseViolations(docs, policy)
+	if len(violations) == 0 {
+		return nil
+	}
+	for _, violation := range violations {
+		fmt.Fprintf(report, "license %s: %s\n", policy.Action, violation)
+	}
+	if policy.Action == licenseRefuse {
+		return fmt.Errorf("export refused by the license policy")
+	}
+	return nil
+}
+
+// sortedLicenses returns the licenses in a map, rules without a license
+// last.
+func sortedLicenses(rulesByLicense map[string][]string) []string {
+	licenses := []string{}
+	for license := range rulesByLicense {
+		licenses = append(licenses, license)
+	}
+	sort.Slice(licenses, func(i, j int) bool {
+		if (licenses[i] == "") != (licenses[j] == "") {
+			return licenses[j] == ""
+		}
+		return licenses[i] < licenses[j]
+	})
+	return licenses
+}
+
+// Sources and authors of the exported rules under one license.
+type licenseAttributionType struct {
+	License string
+	Rules   int
+	Sources []string
+	Authors []string
+}
+
+// licenseName returns the license of a rule for people, which may be
+// unknown.
+func licenseName(license string) string {
+	if license == "" {
+		return "Unknown license"
+	}
+	return license
+}
+
+func ruleSourceName(doc *yaraRuleType) string {
+	if doc.Feed != "" {
+		return "feed " + doc.Feed
+	}
+	return filepath.Dir(doc.RulesetName)
+}
+
+// licenseAttributions groups the sources and authors of rules by license,
+// rules without a license last.
+func licenseAttributions(docs []*yaraRuleType) []*licenseAttributionType {
+	sources := map[string]MapSet{}
+	authors := map[string]MapSet{}
+	rulesByLicense := map[string][]string{}
+	for _, doc := range docs {
+		license := doc.License
+		if sources[license] == nil {
+			sources[license] = MapSet{}
+			authors[license] = MapSet{}
+		}
+		rulesByLicense[license] = append(rulesByLicense[license], doc.ID)
+		sources[license].Add(ruleSourceName(doc))
+		authors[license].AddFromSlice(doc.Metadata["author"])
+	}
+	result := []*licenseAttributionType{}
+	for _, license := range sortedLicenses(rulesByLicense) {
+		result = append(result, &licenseAttributionType{
+			License: license,
+			Rules:   len(rulesByLicense[license]),
+			Sources: sortedKeys(sources[license]),
+			Authors: sortedKeys(authors[license]),
+		})
+	}
+	return result
+}
+
+// statement names the license, sources and authors in one sentence.
+func (attribution *licenseAttributionType) statement() string {
+	statement := "License unknown."
+	if attribution.License != "" {
+		statement = "Licensed under " + attribution.License + "."
+	}
+	statement += " Sources: " + strings.Join(attribution.Sources, ", ") + "."
+	if len(attribution.Authors) > 0 {
+		statement += fmt.Sprintf(" Authors: %s.", strings.Join(attribution.Authors, ", "))
+	}
+	return statement
+}
+
+// ruleAttribution credits the source and authors of a rule and names its
+// license.
+func ruleAttribution(doc *yaraRuleType) string {
+	attribution := "From " + ruleSourceName(doc)
+	if authors := doc.Metadata["author"]; len(authors) > 0 {
+		attribution += " by " + strings.Join(authors, ", ")
+	}
+	if doc.License == "" {
+		return attribution + ", license unknown"
+	}
+	return attribution + ", licensed under " + doc.License
+}
+
+// commentText makes text safe to write on a single line of a YARA comment.
+func commentText(text string) string {
+	text = strings.ReplaceAll(text, "*/", "* /")
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// writeAttribution writes a comment naming the license, sources and
+// authors of the exported rules.
+func writeAttribution(out io.Writer, docs []*yaraRuleType) {
+	fmt.Fprintln(out, "/*")
+	fmt.Fprintln(out, " * These rules keep the licenses of their sources.")
+	for _, attribution := range licenseAttributions(docs) {
+		fmt.Fprintf(out, " *\n * %s\n", commentText(licenseName(attribution.License)))
+		fmt.Fprintf(out, " *   Rules: %d\n", attribution.Rules)
+		fmt.Fprintf(out, " *   Sources: %s\n", commentText(strings.Join(attribution.Sources, ", ")))
+		if len(attribution.Authors) > 0 {
+			fmt.Fprintf(out, " *   Authors: %s\n", commentText(strings.Join(attribution.Authors, ", ")))
+		}
+	}
+	fmt.Fprintln(out, " */")
+	fmt.Fprintln(out)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIdentifyLicenseText(t *testing.T) {
+	tests := map[string]string{
+		"Apache-2.0": "Apache-2.0",
+		"BSD":        "BSD-3-Clause",
+		"CC0-1.0":    "CC0-1.0",
+		"GPL-1":      "",
+		"GPL-2":      "GPL-2.0-only",
+		"GPL-3":      "GPL-3.0-only",
+		"LGPL-2":     "",
+		"LGPL-2.1":   "LGPL-2.1-only",
+		"LGPL-3":     "LGPL-3.0-only",
+		"MPL-1.1":    "",
+		"MPL-2.0":    "MPL-2.0",
+	}
+	for name, expected := range tests {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", "licenses", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id := identifyLicenseText(string(data)); id != expected {
+			t.Errorf("%s: expected %q, found %q", name, expected, id)
+		}
+	}
+}
+
+func TestSetRuleLicense(t *testing.T) {
+	metadata := map[string][]string{
+		"license_url": {"https://example.com/LICENSE"},
+		"licence":     {"MIT"},
+		"license":     {"GPLv3"},
+	}
+	for i := 0; i < 20; i++ {
+		doc := &yaraRuleType{Metadata: metadata}
+		setRuleLicense(doc, &licenseType{"Apache-2.0", licenseFromFile})
+		if doc.License != "GPL-3.0-only" || doc.LicenseSource != licenseFromMeta {
+			t.Fatalf("expected the license key to win, found %s from %s", doc.License, doc.LicenseSource)
+		}
+	}
+	delete(metadata, "license")
+	doc := &yaraRuleType{Metadata: metadata}
+	setRuleLicense(doc, nil)
+	if doc.License != "MIT" {
+		t.Errorf("expected the first key in order to win, found %s", doc.License)
+	}
+}
+
+func TestLicenseAttributions(t *testing.T) {
+	docs := []*yaraRuleType{
+		{ID: "a", RulesetName: "feeds/x/a.yar", License: "MIT", Metadata: map[string][]string{"author": {"Ann"}}},
+		{ID: "b", RulesetName: "rules/b.yar", Feed: "x", License: "MIT", Metadata: map[string][]string{"author": {"Bob", "Ann"}}},
+		{ID: "c", RulesetName: "rules/c.yar"},
+	}
+	attributions := licenseAttributions(docs)
+	if len(attributions) != 2 || attributions[1].License != "" {
+		t.Fatalf("expected MIT and unknown licenses, found %v", attributions)
+	}
+	expected := "Licensed under MIT. Sources: feed x, feeds/x. Authors: Ann, Bob."
+	if statement := attributions[0].statement(); statement != expected {
+		t.Errorf("expected %q, found %q", expected, statement)
+	}
+	if attribution := ruleAttribution(docs[2]); attribution != "From rules, license unknown" {
+		t.Errorf("unexpected attribution %q", attribution)
+	}
+}
+
+func TestWriteAttribution(t *testing.T) {
+	docs := []*yaraRuleType{
+		{ID: "a", RulesetName: "rules/a.yar", License: "MIT", Metadata: map[string][]string{"author": {"Ann */ rule x {}\n// Bob"}}},
+	}
+	out := &bytes.Buffer{}
+	writeAttribution(out, docs)
+	if strings.Count(out.String(), "*/") != 1 {
+		t.Errorf("comment not closed exactly once:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), " *   Authors: Ann * / rule x {} // Bob\n") {
+		t.Errorf("unexpected authors in:\n%s", out.String())
+	}
+}
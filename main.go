@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/blevesearch/bleve"
 	toml "github.com/pelletier/go-toml"
 	"github.com/rs/zerolog/log"
 )
@@ -19,9 +20,24 @@ type YaramanContext struct {
 	databaseDir    string
 	rulesDir       string
 	exportDir      string
+	samplesDir     string
 	logLevel       string
 	fileExtensions MapSet
 	repoHosts      MapSet
+	feeds          []*feedType
+	syncInterval   time.Duration
+	index          bleve.Index
+	batch          *bleve.Batch
+	// Report of the import in progress, if any
+	report *importReportType
+	// Feed being synced, if any
+	feed *feedType
+	// Order of day and month in dates, see dateOrderUS
+	dateOrder string
+	// MISP attribute being imported, if any
+	misp *mispSourceType
+	// License of the ruleset being parsed, if any
+	license *licenseType
 }
 
 func makeFullPath(directory string, filename string) string {
@@ -33,6 +49,8 @@ func makeFullPath(directory string, filename string) string {
 
 func initialize(ctx *YaramanContext) {
 	var extensions string
+	aliasesFile := makeFullPath(ctx.execDir, "aliases.txt")
+	mappingFile := makeFullPath(ctx.execDir, "mapping.toml")
 
 	if fileExists(ctx.configFile) {
 		config, err := toml.LoadFile(ctx.configFile)
@@ -45,6 +63,21 @@ func initialize(ctx *YaramanContext) {
 		ctx.rulesDir = config.GetDefault("yaraman.rules_dir", ctx.rulesDir).(string)
 		ctx.databaseDir = config.GetDefault("yaraman.database_dir", ctx.databaseDir).(string)
 		ctx.exportDir = config.GetDefault("yaraman.export_dir", ctx.exportDir).(string)
+		ctx.samplesDir = config.GetDefault("yaraman.samples_dir", ctx.samplesDir).(string)
+		aliasesFile = config.GetDefault("yaraman.aliases_file", aliasesFile).(string)
+		mappingFile = config.GetDefault("yaraman.mapping_file", mappingFile).(string)
+		ctx.dateOrder = config.GetDefault("yaraman.date_order", "").(string)
+		err = validateDateOrder(ctx.dateOrder)
+		if err != nil {
+			logger.Fatal().AnErr("error", err).Str("config_file", ctx.configFile).Msg("Invalid date order.")
+		}
+		attackFile := config.GetDefault("yaraman.attack_file", "").(string)
+		if attackFile != "" {
+			attackDataset, err = loadAttackBundle(attackFile)
+			if err != nil {
+				logger.Fatal().AnErr("error", err).Str("attack_file", attackFile).Msg("Could not load the ATT&CK dataset.")
+			}
+		}
 
 		extensions = config.GetDefault("yaraman.file_extensions", "yara,yar").(string)
 		// Only use the config file extensions if they were not specified on the command line
@@ -60,6 +93,26 @@ func initialize(ctx *YaramanContext) {
 		for _, host := range hosts {
 			ctx.repoHosts.Add(host)
 		}
+
+		qualityWeights, err = loadQualityWeights(config)
+		if err != nil {
+			logger.Fatal().AnErr("error", err).Str("config_file", ctx.configFile).Msg("Invalid quality weights.")
+		}
+
+		licensePolicy, err = loadLicensePolicy(config)
+		if err != nil {
+			logger.Fatal().AnErr("error", err).Str("config_file", ctx.configFile).Msg("Invalid license policy.")
+		}
+
+		ctx.feeds, err = loadFeeds(config)
+		if err != nil {
+			logger.Fatal().AnErr("error", err).Str("config_file", ctx.configFile).Msg("Invalid feed configuration.")
+		}
+		interval := config.GetDefault("yaraman.sync_interval", "1h").(string)
+		ctx.syncInterval, err = time.ParseDuration(interval)
+		if err != nil {
+			logger.Fatal().AnErr("error", err).Str("sync_interval", interval).Msg("Invalid sync interval.")
+		}
 	} else {
 		initLogging(ctx)
 		logger.Info().Msg("No configuration file, using default settings.")
@@ -71,6 +124,9 @@ func initialize(ctx *YaramanContext) {
 	if len(ctx.repoHosts) == 0 {
 		ctx.repoHosts.Add("github.com")
 	}
+	if ctx.syncInterval == 0 {
+		ctx.syncInterval = time.Hour
+	}
 	logger.Debug().Msgf("%v", ctx)
 
 	loc, err := time.LoadLocation("UTC")
@@ -79,10 +135,28 @@ func initialize(ctx *YaramanContext) {
 	}
 	time.Local = loc
 
-	if !fileExists(makeFullPath(ctx.execDir, "normalized_tags.txt")) {
-		logger.Fatal().Str("filename", makeFullPath(ctx.execDir, "normalized_tags.txt")).Msg("File not found")
+	// normalized_tags.txt is still read if there is no mapping file
+	legacyMappingFile := makeFullPath(ctx.execDir, "normalized_tags.txt")
+	switch {
+	case fileExists(mappingFile):
+		metaMappings, err = loadMetaMappings(mappingFile)
+	case fileExists(legacyMappingFile):
+		logger.Warn().Str("filename", legacyMappingFile).Msg("normalized_tags.txt is deprecated, use mapping.toml.")
+		metaMappings, err = loadLegacyMappings(legacyMappingFile)
+	default:
+		logger.Fatal().Str("filename", mappingFile).Msg("File not found")
+	}
+	if err != nil {
+		logger.Fatal().AnErr("error", err).Msg("Could not read the metadata mapping.")
+	}
+
+	// The alias dictionary is optional
+	if fileExists(aliasesFile) {
+		err := readAliases(aliasesFile)
+		if err != nil {
+			logger.Fatal().AnErr("error", err).Str("filename", aliasesFile).Msg("Could not read the alias dictionary.")
+		}
 	}
-	readNormalizedMetaTags(makeFullPath(ctx.execDir, "normalized_tags.txt"))
 }
 
 func main() {
@@ -109,6 +183,7 @@ func main() {
 		databaseDir:    makeFullPath(execDir, "db"),
 		logDir:         makeFullPath(execDir, "log"),
 		exportDir:      makeFullPath(execDir, "export"),
+		samplesDir:     makeFullPath(execDir, "samples"),
 		repoHosts:      MapSet{},
 	}
 	if CLI.Extensions != "" {
@@ -118,9 +193,14 @@ func main() {
 	}
 	initialize(ctx)
 	logger.Debug().Msgf("context: %v", ctx)
-	kongContext.Run(ctx)
-	//	if err != nil {
-	//		logger.Fatal().AnErr("error", err).Msg("Fatal error, terminating")
-	//	}
-	//	kongContext.FatalIfErrorf(err)
+	err := initializeBleve(ctx)
+	if err != nil {
+		logger.Fatal().AnErr("error", err).Str("directory", ctx.databaseDir).Msg("Could not open the index.")
+	}
+	err = kongContext.Run(ctx)
+	closeBleve(ctx)
+	if err != nil {
+		errorLogger.Error().AnErr("error", err).Msg("Command failed")
+	}
+	kongContext.FatalIfErrorf(err)
 }
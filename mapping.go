@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	toml "github.com/pelletier/go-toml"
+)
+
+// Kinds of keys of metadata mappings
+const (
+	mappingExact  = "exact"
+	mappingPrefix = "prefix"
+	mappingRegex  = "regex"
+)
+
+// Transformations of metadata values
+const (
+	transformLowercase = "lowercase"
+	transformDate      = "date"
+	transformSplit     = "split"
+)
+
+// Values of these fields are always normalized as dates
+var dateFields = MapSet{"creation_date": true, "last_modified": true, "release_date": true}
+
+// Mapping of metadata keys to a normalized field, defined by a [[mapping]]
+// section in mapping.toml. For example
+//
+//	[[mapping]]
+//	match = "prefix"
+//	key = "desc"
+//	field = "description"
+//
+// maps desc, descr and description to description.
+type metaMappingType struct {
+	// One of exact, prefix or regex, exact if empty
+	Match string `toml:"match"`
+	Key   string `toml:"key"`
+	// Normalized key, the metadata key is kept if empty
+	Field string `toml:"field"`
+	// Of the mappings matching a key, the one with the highest priority is
+	// used. Ties go to exact keys, then regular expressions, then the
+	// longest prefix, then the mapping that comes first.
+	Priority   int      `toml:"priority"`
+	Transforms []string `toml:"transform"`
+
+	// Where the mapping is defined, for error messages and mapping test
+	source string
+	line   int
+	order  int
+	re     *regexp.Regexp
+}
+
+var (
+	metaMappings = []*metaMappingType{}
+
+	// Precedence of match kinds on equal priority
+	mappingRanks = map[string]int{mappingExact: 2, mappingRegex: 1, mappingPrefix: 0}
+)
+
+func (mapping *metaMappingType) validate() error {
+	if mapping.Match == "" {
+		mapping.Match = mappingExact
+	}
+	if _, ok := mappingRanks[mapping.Match]; !ok {
+		return fmt.Errorf("unknown match %q, expected exact, prefix or regex", mapping.Match)
+	}
+	if mapping.Key == "" {
+		return fmt.Errorf("key is required")
+	}
+	mapping.Key = strings.ToLower(mapping.Key)
+	if mapping.Match == mappingRegex {
+		re, err := regexp.Compile(mapping.Key)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression %q: %v", mapping.Key, err)
+		}
+		mapping.re = re
+	}
+	for _, transform := range mapping.Transforms {
+		switch transform {
+		case transformLowercase, transformDate, transformSplit:
+		default:
+			return fmt.Errorf("unknown transform %q, expected lowercase, date or split", transform)
+		}
+	}
+	return nil
+}
+
+func (mapping *metaMappingType) matches(key string) bool {
+	switch mapping.Match {
+	case mappingPrefix:
+		return strings.HasPrefix(key, mapping.Key)
+	case mappingRegex:
+		return mapping.re.MatchString(key)
+	}
+	return key == mapping.Key
+}
+
+// precedes reports whether mapping wins over other when both match.
+func (mapping *metaMappingType) precedes(other *metaMappingType) bool {
+	if mapping.Priority != other.Priority {
+		return mapping.Priority > other.Priority
+	}
+	if mapping.Match != other.Match {
+		return mappingRanks[mapping.Match] > mappingRanks[other.Match]
+	}
+	if len(mapping.Key) != len(other.Key) {
+		return len(mapping.Key) > len(other.Key)
+	}
+	return mapping.order < other.order
+}
+
+func (mapping *metaMappingType) String() string {
+	return fmt.Sprintf("%s:%d: %s %q priority %d", mapping.source, mapping.line, mapping.Match, mapping.Key, mapping.Priority)
+}
+
+// loadMetaMappings reads the [[mapping]] sections of a mapping file.
+func loadMetaMappings(filename string) ([]*metaMappingType, error) {
+	tree, err := toml.LoadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for _, key := range tree.Keys() {
+		if key != "mapping" {
+			return nil, fmt.Errorf("%s:%d: unknown section %s, expected [[mapping]]", filename, tree.GetPosition(key).Line, key)
+		}
+	}
+	mappings := []*metaMappingType{}
+	trees, ok := tree.Get("mapping").([]*toml.Tree)
+	if !ok && tree.Has("mapping") {
+		return nil, fmt.Errorf("%s:%d: mapping must be an array of tables, [[mapping]]", filename, tree.GetPosition("mapping").Line)
+	}
+	for i, mappingTree := range trees {
+		line := mappingTree.Position().Line
+		for _, key := range mappingTree.Keys() {
+			switch key {
+			case "match", "key", "field", "priority", "transform":
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown setting %s", filename, mappingTree.GetPosition(key).Line, key)
+			}
+		}
+		mapping := &metaMappingType{source: filename, line: line, order: i}
+		err = mappingTree.Unmarshal(mapping)
+		if err == nil {
+			err = mapping.validate()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, line, err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// loadLegacyMappings reads a normalized_tags.txt file of key,field lines
+// as prefix mappings. A line with only a key, or an empty field, keeps the
+// key.
+func loadLegacyMappings(filename string) ([]*metaMappingType, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	mappings := []*metaMappingType{}
+	for n, line := range strings.Split(strings.ReplaceAll(string(data), "\r", ""), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		parts := strings.Split(line, ",")
+		if len(parts) > 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("%s:%d: expected key,field but got %q", filename, n+1, line)
+		}
+		mapping := &metaMappingType{
+			Match:  mappingPrefix,
+			Key:    strings.TrimSpace(parts[0]),
+			source: filename,
+			line:   n + 1,
+			order:  len(mappings),
+		}
+		if len(parts) == 2 {
+			mapping.Field = strings.TrimSpace(parts[1])
+		}
+		err = mapping.validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, n+1, err)
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// matchingMappings returns the mappings that match a metadata key, the
+// one that applies first.
+func matchingMappings(key string) []*metaMappingType {
+	result := []*metaMappingType{}
+	for _, mapping := range metaMappings {
+		if mapping.matches(key) {
+			result = append(result, mapping)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].precedes(result[j]) })
+	return result
+}
+
+// resolveMetaKey returns the normalized field of a metadata key and the
+// mapping that applies to it, nil if there is none.
+func resolveMetaKey(key string) (string, *metaMappingType) {
+	key = strings.ToLower(key)
+	mappings := matchingMappings(key)
+	if len(mappings) == 0 {
+		return key, nil
+	}
+	if mappings[0].Field == "" {
+		return key, mappings[0]
+	}
+	if key != mappings[0].Field {
+		logger.Trace().Str("from_name", key).Str("to_name", mappings[0].Field).Msg("metadata map")
+	}
+	return mappings[0].Field, mappings[0]
+}
+
+// transformMetaValue applies the transforms of a mapping to a value. The
+// date transform reads dates in the given order and also returns them with
+// their raw value. Values that are not dates are left out of the result.
+func transformMetaValue(field string, mapping *metaMappingType, value string, dateOrder string) ([]string, []*ruleDateType) {
+	transforms := []string{}
+	if mapping != nil {
+		transforms = mapping.Transforms
+	}
+	if dateFields.Contains(field) && !containsString(transforms, transformDate) {
+		transforms = append(append([]string{}, transforms...), transformDate)
+	}
+	values := []string{value}
+	dates := []*ruleDateType{}
+	for _, transform := range transforms {
+		result := []string{}
+		for _, value := range values {
+			switch transform {
+			case transformLowercase:
+				result = append(result, strings.ToLower(value))
+			case transformDate:
+				date := parseDate(value, dateOrder)
+				date.Field = field
+				dates = append(dates, date)
+				if date.Date != "" {
+					logger.Trace().Str("original_date", value).Str("normalized_date", date.Date).Str("confidence", date.Confidence).Msg("metadata date")
+					result = append(result, date.Date)
+				}
+			case transformSplit:
+				for _, part := range strings.Split(value, ",") {
+					part = strings.TrimSpace(part)
+					if part != "" {
+						result = append(result, part)
+					}
+				}
+			}
+		}
+		values = result
+	}
+	return values, dates
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/VirusTotal/gyp/ast"
+)
+
+const (
+	// Maximum number of matches recorded per string, as in YARA
+	maxStringMatches = 1000000
+	// Block size of the offset index of latin1ViewType
+	latin1BlockSize = 4096
+	standardBase64  = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+)
+
+// Match of a string in the scanned data.
+type stringMatchType struct {
+	Offset int `json:"offset"`
+	Length int `json:"length"`
+}
+
+// A compiled string definition that can find its matches in data.
+type stringMatcherType interface {
+	find(data *scanDataType) []stringMatchType
+}
+
+// Data being scanned along with views of it built on demand.
+type scanDataType struct {
+	data   []byte
+	lower  []byte
+	latin1 *latin1ViewType
+	// Narrow views of UTF-16LE text at even and odd offsets
+	wide [2][]*wideSegmentType
+}
+
+// Regular expressions work on UTF-8 text, so data is matched as a string
+// in which every byte is encoded as the rune with the same value.
+type latin1ViewType struct {
+	text string
+	// Offset in text of the start of every block of data
+	blocks []int
+	data   []byte
+}
+
+// A run of UTF-16LE characters in the 0-255 range, narrowed to one byte
+// per character.
+type wideSegmentType struct {
+	offset int
+	view   *latin1ViewType
+}
+
+func newScanData(data []byte) *scanDataType {
+	return &scanDataType{data: data}
+}
+
+func isAlnum(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func lowerByte(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// asciiLower lowers ASCII letters only, leaving every other byte as is.
+func asciiLower(data []byte) []byte {
+	lower := make([]byte, len(data))
+	for i, b := range data {
+		lower[i] = lowerByte(b)
+	}
+	return lower
+}
+
+func (scan *scanDataType) lowered() []byte {
+	if scan.lower == nil {
+		scan.lower = asciiLower(scan.data)
+	}
+	return scan.lower
+}
+
+func newLatin1View(data []byte) *latin1ViewType {
+	var builder strings.Builder
+	builder.Grow(len(data))
+	blocks := make([]int, 0, len(data)/latin1BlockSize+1)
+	for i, b := range data {
+		if i%latin1BlockSize == 0 {
+			blocks = append(blocks, builder.Len())
+		}
+		builder.WriteRune(rune(b))
+	}
+	return &latin1ViewType{text: builder.String(), blocks: blocks, data: data}
+}
+
+// dataOffset converts an offset in the text to an offset in the data.
+func (view *latin1ViewType) dataOffset(textOffset int) int {
+	// Last block starting at or before the offset
+	block := sort.Search(len(view.blocks), func(i int) bool {
+		return view.blocks[i] > textOffset
+	}) - 1
+	if block < 0 {
+		block = 0
+	}
+	offset := block * latin1BlockSize
+	for position := view.blocks[block]; position < textOffset; offset++ {
+		if view.data[offset] < utf8.RuneSelf {
+			position++
+		} else {
+			position += 2
+		}
+	}
+	return offset
+}
+
+func (scan *scanDataType) latin1View() *latin1ViewType {
+	if scan.latin1 == nil {
+		scan.latin1 = newLatin1View(scan.data)
+	}
+	return scan.latin1
+}
+
+// wideSegments returns the runs of UTF-16LE characters starting at even
+// or odd offsets.
+func (scan *scanDataType) wideSegments(alignment int) []*wideSegmentType {
+	if scan.wide[alignment] != nil {
+		return scan.wide[alignment]
+	}
+	segments := []*wideSegmentType{}
+	start := -1
+	narrow := []byte{}
+	flush := func() {
+		if start >= 0 && len(narrow) > 0 {
+			segments = append(segments, &wideSegmentType{offset: start, view: newLatin1View(narrow)})
+		}
+		start = -1
+		narrow = []byte{}
+	}
+	for i := alignment; i+1 < len(scan.data); i += 2 {
+		if scan.data[i+1] != 0 {
+			flush()
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		narrow = append(narrow, scan.data[i])
+	}
+	flush()
+	scan.wide[alignment] = segments
+	return segments
+}
+
+// fullwordAt reports whether a match is delimited by non alphanumeric
+// characters. Wide matches check the surrounding UTF-16 characters.
+func fullwordAt(data []byte, offset int, length int, wide bool) bool {
+	if wide {
+		if offset >= 2 && isAlnum(data[offset-2]) && data[offset-1] == 0 {
+			return false
+		}
+		end := offset + length
+		return !(end+1 < len(data) && isAlnum(data[end]) && data[end+1] == 0)
+	}
+	if offset >= 1 && isAlnum(data[offset-1]) {
+		return false
+	}
+	end := offset + length
+	return !(end < len(data) && isAlnum(data[end]))
+}
+
+func widen(s []byte) []byte {
+	wide := make([]byte, 0, len(s)*2)
+	for _, b := range s {
+		wide = append(wide, b, 0)
+	}
+	return wide
+}
+
+// One byte sequence a text string can match, such as its wide form or one
+// of its xor encodings.
+type textVariantType struct {
+	pattern []byte
+	wide    bool
+}
+
+type textMatcherType struct {
+	variants []*textVariantType
+	nocase   bool
+	fullword bool
+}
+
+// base64Variants returns the three base64 encodings of s, one per
+// alignment of s within the encoded data, without the characters that
+// depend on the surrounding bytes.
+func base64Variants(s []byte, alphabet string) ([][]byte, error) {
+	if alphabet == "" {
+		alphabet = standardBase64
+	}
+	if len(alphabet) != 64 {
+		return nil, fmt.Errorf("base64 alphabet must have 64 characters")
+	}
+	encoding := base64.NewEncoding(alphabet).WithPadding(base64.NoPadding)
+	variants := [][]byte{}
+	for i, skip := range []int{0, 2, 3} {
+		padded := append(make([]byte, i), s...)
+		encoded := encoding.EncodeToString(padded)
+		end := len(encoded)
+		if len(padded)%3 != 0 {
+			end--
+		}
+		if end > skip {
+			variants = append(variants, []byte(encoded[skip:end]))
+		}
+	}
+	return variants, nil
+}
+
+func newTextMatcher(s *ast.TextString) (*textMatcherType, error) {
+	value := []byte(s.UnescapedValue())
+
+	matcher := &textMatcherType{nocase: s.Nocase, fullword: s.Fullword}
+	if s.Base64 || s.Base64Wide {
+		variants, err := base64Variants(value, s.Base64Alphabet)
+		if err != nil {
+			return nil, fmt.Errorf("string $%s: %v", s.Identifier, err)
+		}
+		for _, variant := range variants {
+			if s.Base64 {
+				matcher.variants = append(matcher.variants, &textVariantType{pattern: variant})
+			}
+			if s.Base64Wide {
+				matcher.variants = append(matcher.variants, &textVariantType{pattern: widen(variant), wide: true})
+			}
+		}
+		return matcher, nil
+	}
+
+	forms := []*textVariantType{}
+	if s.ASCII || !s.Wide {
+		forms = append(forms, &textVariantType{pattern: value})
+	}
+	if s.Wide {
+		forms = append(forms, &textVariantType{pattern: widen(value), wide: true})
+	}
+	if !s.Xor {
+		matcher.variants = forms
+		if matcher.nocase {
+			for _, form := range forms {
+				form.pattern = asciiLower(form.pattern)
+			}
+		}
+		return matcher, nil
+	}
+	for _, form := range forms {
+		for key := s.XorMin; key <= s.XorMax; key++ {
+			pattern := make([]byte, len(form.pattern))
+			for i, b := range form.pattern {
+				pattern[i] = b ^ byte(key)
+			}
+			matcher.variants = append(matcher.variants, &textVariantType{pattern: pattern, wide: form.wide})
+		}
+	}
+	return matcher, nil
+}
+
+func (matcher *textMatcherType) find(scan *scanDataType) []stringMatchType {
+	data := scan.data
+	if matcher.nocase {
+		data = scan.lowered()
+	}
+	matches := []stringMatchType{}
+	seen := map[int]bool{}
+	for _, variant := range matcher.variants {
+		if len(variant.pattern) == 0 {
+			continue
+		}
+		for start := 0; start < len(data); {
+			index := bytes.Index(data[start:], variant.pattern)
+			if index < 0 {
+				break
+			}
+			offset := start + index
+			start = offset + 1
+			if seen[offset] {
+				continue
+			}
+			if matcher.fullword && !fullwordAt(scan.data, offset, len(variant.pattern), variant.wide) {
+				continue
+			}
+			seen[offset] = true
+			matches = append(matches, stringMatchType{Offset: offset, Length: len(variant.pattern)})
+			if len(matches) >= maxStringMatches {
+				return sortMatches(matches)
+			}
+		}
+	}
+	return sortMatches(matches)
+}
+
+func sortMatches(matches []stringMatchType) []stringMatchType {
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Offset < matches[j].Offset })
+	return matches
+}
+
+type hexMatcherType struct {
+	tokens ast.HexTokens
+	// Leading bytes without wildcards, used to find candidate offsets
+	prefix []byte
+}
+
+func newHexMatcher(s *ast.HexString) *hexMatcherType {
+	matcher := &hexMatcherType{tokens: s.Tokens}
+	if len(s.Tokens) > 0 {
+		if hexBytes, ok := s.Tokens[0].(*ast.HexBytes); ok {
+			for i, mask := range hexBytes.Masks {
+				if mask != 0xFF {
+					break
+				}
+				matcher.prefix = append(matcher.prefix, hexBytes.Bytes[i])
+			}
+		}
+	}
+	return matcher
+}
+
+// matchHexTokens tries to match tokens at offset, calling next with the
+// end offset of every way the tokens match until next returns true.
+func matchHexTokens(tokens []ast.HexToken, data []byte, offset int, next func(int) bool) bool {
+	if len(tokens) == 0 {
+		return next(offset)
+	}
+	rest := tokens[1:]
+	switch token := tokens[0].(type) {
+	case *ast.HexBytes:
+		if offset+len(token.Bytes) > len(data) {
+			return false
+		}
+		for i, b := range token.Bytes {
+			if data[offset+i]&token.Masks[i] != b&token.Masks[i] {
+				return false
+			}
+		}
+		return matchHexTokens(rest, data, offset+len(token.Bytes), next)
+	case *ast.HexJump:
+		// An end of 0 means the jump is unbounded
+		end := offset + token.End
+		if token.End == 0 {
+			end = len(data)
+		}
+		for position := offset + token.Start; position <= end && position <= len(data); position++ {
+			if matchHexTokens(rest, data, position, next) {
+				return true
+			}
+		}
+		return false
+	case *ast.HexOr:
+		for _, alternative := range token.Alternatives {
+			var sequence []ast.HexToken
+			if alternativeTokens, ok := alternative.(ast.HexTokens); ok {
+				sequence = alternativeTokens
+			} else {
+				sequence = []ast.HexToken{alternative}
+			}
+			matched := matchHexTokens(sequence, data, offset, func(end int) bool {
+				return matchHexTokens(rest, data, end, next)
+			})
+			if matched {
+				return true
+			}
+		}
+		return false
+	case ast.HexTokens:
+		return matchHexTokens(token, data, offset, func(end int) bool {
+			return matchHexTokens(rest, data, end, next)
+		})
+	}
+	return false
+}
+
+func (matcher *hexMatcherType) find(scan *scanDataType) []stringMatchType {
+	data := scan.data
+	matches := []stringMatchType{}
+	tryOffset := func(offset int) {
+		matchHexTokens(matcher.tokens, data, offset, func(end int) bool {
+			matches = append(matches, stringMatchType{Offset: offset, Length: end - offset})
+			return true
+		})
+	}
+	if len(matcher.prefix) == 0 {
+		for offset := 0; offset < len(data) && len(matches) < maxStringMatches; offset++ {
+			tryOffset(offset)
+		}
+		return matches
+	}
+	for start := 0; start < len(data) && len(matches) < maxStringMatches; {
+		index := bytes.Index(data[start:], matcher.prefix)
+		if index < 0 {
+			break
+		}
+		tryOffset(start + index)
+		start += index + 1
+	}
+	return matches
+}
+
+type regexMatcherType struct {
+	re       *regexp.Regexp
+	ascii    bool
+	wide     bool
+	fullword bool
+}
+
+// compileYaraRegexp compiles a YARA regular expression so that it
+// matches a latin1ViewType. Like YARA, nocase only folds ASCII letters,
+// while the i flag of Go would also fold the letters above 0x7F.
+func compileYaraRegexp(literal *ast.LiteralRegexp, nocase bool) (*regexp.Regexp, error) {
+	pattern := literal.Value
+	if literal.Modifiers&ast.RegexpDotAll != 0 {
+		pattern = "(?s)" + pattern
+	}
+	if nocase || literal.Modifiers&ast.RegexpCaseInsensitive != 0 {
+		parsed, err := syntax.Parse(pattern, syntax.Perl)
+		if err != nil {
+			return nil, err
+		}
+		pattern = foldASCII(parsed).String()
+	}
+	return regexp.Compile(pattern)
+}
+
+// normalizeRanges sorts character class ranges and merges the ones that
+// overlap or touch.
+func normalizeRanges(ranges []rune) []rune {
+	pairs := [][2]rune{}
+	for i := 0; i+1 < len(ranges); i += 2 {
+		pairs = append(pairs, [2]rune{ranges[i], ranges[i+1]})
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i][0] < pairs[j][0] })
+	result := []rune{}
+	for _, pair := range pairs {
+		if n := len(result); n > 0 && pair[0] <= result[n-1]+1 {
+			if pair[1] > result[n-1] {
+				result[n-1] = pair[1]
+			}
+			continue
+		}
+		result = append(result, pair[0], pair[1])
+	}
+	return result
+}
+
+// negateRanges returns the runes not in normalized character class ranges.
+func negateRanges(ranges []rune) []rune {
+	result := []rune{}
+	next := rune(0)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		if ranges[i] > next {
+			result = append(result, next, ranges[i]-1)
+		}
+		next = ranges[i+1] + 1
+	}
+	if next <= unicode.MaxRune {
+		result = append(result, next, unicode.MaxRune)
+	}
+	return result
+}
+
+// asciiCaseRanges adds the other case of the ASCII letters in character
+// class ranges. Like YARA, a negated class is folded before it is negated,
+// so [^x] matches neither x nor X.
+func asciiCaseRanges(ranges []rune) []rune {
+	ranges = normalizeRanges(ranges)
+	if len(ranges) > 0 && ranges[len(ranges)-1] == unicode.MaxRune {
+		return negateRanges(asciiCaseRanges(negateRanges(ranges)))
+	}
+	result := append([]rune{}, ranges...)
+	for i := 0; i+1 < len(ranges); i += 2 {
+		for _, letters := range [][3]rune{{'a', 'z', 'A' - 'a'}, {'A', 'Z', 'a' - 'A'}} {
+			lo, hi := ranges[i], ranges[i+1]
+			if lo < letters[0] {
+				lo = letters[0]
+			}
+			if hi > letters[1] {
+				hi = letters[1]
+			}
+			if lo <= hi {
+				result = append(result, lo+letters[2], hi+letters[2])
+			}
+		}
+	}
+	return normalizeRanges(result)
+}
+
+// foldASCII makes a parsed regular expression match ASCII letters in
+// either case.
+func foldASCII(re *syntax.Regexp) *syntax.Regexp {
+	switch re.Op {
+	case syntax.OpLiteral:
+		parts := []*syntax.Regexp{}
+		for _, r := range re.Rune {
+			part := &syntax.Regexp{Op: syntax.OpLiteral, Rune: []rune{r}, Flags: re.Flags}
+			if lower := unicode.ToLower(r); r < utf8.RuneSelf && lower != unicode.ToUpper(r) {
+				part = &syntax.Regexp{Op: syntax.OpCharClass, Rune: []rune{lower, lower, unicode.ToUpper(r), unicode.ToUpper(r)}, Flags: re.Flags}
+			}
+			parts = append(parts, part)
+		}
+		if len(parts) == 1 {
+			return parts[0]
+		}
+		return &syntax.Regexp{Op: syntax.OpConcat, Sub: parts, Flags: re.Flags}
+	case syntax.OpCharClass:
+		re.Rune = asciiCaseRanges(re.Rune)
+	}
+	for i, sub := range re.Sub {
+		re.Sub[i] = foldASCII(sub)
+	}
+	return re
+}
+
+func newRegexMatcher(s *ast.RegexpString) (*regexMatcherType, error) {
+	re, err := compileYaraRegexp(s.Regexp, s.Nocase)
+	if err != nil {
+		return nil, fmt.Errorf("string $%s: %v", s.Identifier, err)
+	}
+	return &regexMatcherType{
+		re:       re,
+		ascii:    s.ASCII || !s.Wide,
+		wide:     s.Wide,
+		fullword: s.Fullword,
+	}, nil
+}
+
+func (matcher *regexMatcherType) findIn(view *latin1ViewType, base int, scale int, data []byte, matches []stringMatchType) []stringMatchType {
+	for _, index := range matcher.re.FindAllStringIndex(view.text, maxStringMatches) {
+		start := view.dataOffset(index[0])
+		end := view.dataOffset(index[1])
+		if end == start {
+			continue
+		}
+		match := stringMatchType{Offset: base + start*scale, Length: (end - start) * scale}
+		if matcher.fullword && !fullwordAt(data, match.Offset, match.Length, scale == 2) {
+			continue
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+func (matcher *regexMatcherType) find(scan *scanDataType) []stringMatchType {
+	matches := []stringMatchType{}
+	if matcher.ascii {
+		matches = matcher.findIn(scan.latin1View(), 0, 1, scan.data, matches)
+	}
+	if matcher.wide {
+		for alignment := 0; alignment < 2; alignment++ {
+			for _, segment := range scan.wideSegments(alignment) {
+				matches = matcher.findIn(segment.view, segment.offset, 2, scan.data, matches)
+			}
+		}
+		matches = sortMatches(matches)
+	}
+	return matches
+}
+
+// newStringMatcher compiles a string definition.
+func newStringMatcher(s ast.String) (stringMatcherType, error) {
+	switch v := s.(type) {
+	case *ast.TextString:
+		return newTextMatcher(v)
+	case *ast.HexString:
+		return newHexMatcher(v), nil
+	case *ast.RegexpString:
+		return newRegexMatcher(v)
+	}
+	return nil, fmt.Errorf("unknown string type %T", s)
+}
@@ -0,0 +1,86 @@
+package main
+
+import "testing"
+
+func TestStringMatchers(t *testing.T) {
+	tests := []struct {
+		definition string
+		data       string
+		matches    int
+	}{
+		{`"Abc"`, "abc ABC Abc", 1},
+		{`"Abc" nocase`, "abc ABC Abc", 3},
+		{`"ab" wide`, "a\x00b\x00 ab", 1},
+		{`"ab" wide ascii`, "a\x00b\x00 ab", 2},
+		{`"ab" nocase wide`, "A\x00b\x00", 1},
+		{`"ab" xor`, "ab \x03\x00 \x62\x61", 3},
+		{`"ab" xor(1-2)`, "ab \x60\x63 \x63\x60", 2},
+		{`"ab" xor(1-2) ascii`, "ab \x60\x63 \x63\x60", 2},
+		{`"ab" xor ascii`, "ab \x03\x00 \x62\x61", 3},
+		{`"ab" xor(0x02-0x03) ascii wide`, "\x63\x02\x60\x02 \x62\x61", 2},
+		{`{ 61 ?? 63 }`, "abc axc ac", 2},
+		{`{ 61 [1-2] 64 }`, "abd abcd ad abccd", 2},
+		{`{ 61 [2] 64 }`, "abcd abd", 1},
+		{`{ 61 ( 62 | 63 ) }`, "ab ac ad", 2},
+		{`/ab+c/`, "abc abbbc ac", 2},
+		{`/ab+c/ nocase`, "ABC aBbC", 2},
+		{`/a[b-d]e/i`, "ACE aBe", 2},
+		{`/[^x]b/ nocase`, "XB ab", 1},
+		{`/[^a-c]/ nocase`, "ABCabcD", 1},
+		// nocase only folds ASCII, like YARA
+		{`/\xe9/ nocase`, "\xc9", 0},
+		{`/k/ nocase`, "\xe2\x84\xaa", 0},
+		{`"\xe9" nocase`, "\xc9", 0},
+	}
+	for _, test := range tests {
+		body := "rule test { strings: $a = " + test.definition + " condition: $a }"
+		rule, err := parseRuleBody(body)
+		if err != nil {
+			t.Fatalf("%s: %v", test.definition, err)
+		}
+		matcher, err := newStringMatcher(rule.Strings[0])
+		if err != nil {
+			t.Fatalf("%s: %v", test.definition, err)
+		}
+		matches := matcher.find(newScanData([]byte(test.data)))
+		if len(matches) != test.matches {
+			t.Errorf("%s on %q: expected %d matches, found %d", test.definition, test.data, test.matches, len(matches))
+		}
+	}
+}
+
+func TestSortMatches(t *testing.T) {
+	matches := sortMatches([]stringMatchType{{Offset: 5}, {Offset: 1}, {Offset: 3}, {Offset: 1, Length: 2}})
+	for i := 1; i < len(matches); i++ {
+		if matches[i].Offset < matches[i-1].Offset {
+			t.Fatalf("not sorted: %v", matches)
+		}
+	}
+	if matches[0].Length != 0 || matches[1].Length != 2 {
+		t.Errorf("equal offsets not kept in order: %v", matches)
+	}
+}
+
+func TestLatin1ViewDataOffset(t *testing.T) {
+	// Bytes above 0x7f take two bytes of text, so text and data offsets
+	// drift apart over several blocks
+	data := make([]byte, 3*latin1BlockSize+10)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	view := newLatin1View(data)
+	textOffset := 0
+	for i, b := range data {
+		if offset := view.dataOffset(textOffset); offset != i {
+			t.Fatalf("text offset %d: expected data offset %d, found %d", textOffset, i, offset)
+		}
+		if b < 0x80 {
+			textOffset++
+		} else {
+			textOffset += 2
+		}
+	}
+	if offset := view.dataOffset(len(view.text)); offset != len(data) {
+		t.Errorf("end of text: expected data offset %d, found %d", len(data), offset)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Category MISP uses for yara attributes
+const mispYaraCategory = "Payload installation"
+
+type mispTagType struct {
+	Name string `json:"name"`
+}
+
+type mispAttributeType struct {
+	UUID     string         `json:"uuid,omitempty"`
+	Type     string         `json:"type"`
+	Category string         `json:"category"`
+	ToIDS    bool           `json:"to_ids"`
+	Value    string         `json:"value"`
+	Comment  string         `json:"comment,omitempty"`
+	Tags     []*mispTagType `json:"Tag,omitempty"`
+}
+
+type mispObjectType struct {
+	Name       string               `json:"name"`
+	Attributes []*mispAttributeType `json:"Attribute"`
+}
+
+type mispEventType struct {
+	UUID          string               `json:"uuid,omitempty"`
+	Info          string               `json:"info"`
+	Date          string               `json:"date"`
+	ThreatLevelID string               `json:"threat_level_id"`
+	Analysis      string               `json:"analysis"`
+	Distribution  string               `json:"distribution"`
+	Tags          []*mispTagType       `json:"Tag,omitempty"`
+	Attributes    []*mispAttributeType `json:"Attribute"`
+	Objects       []*mispObjectType    `json:"Object,omitempty"`
+}
+
+// MISP event files wrap the event in an Event object.
+type mispEventFileType struct {
+	Event *mispEventType `json:"Event"`
+}
+
+// Event and tags of the yara attribute being imported, recorded in the
+// rules it contains.
+type mispSourceType struct {
+	event *mispEventType
+	tags  []string
+}
+
+func mispTags(names []string) []*mispTagType {
+	tags := []*mispTagType{}
+	for _, name := range names {
+		tags = append(tags, &mispTagType{Name: name})
+	}
+	return tags
+}
+
+func mispTagNames(tags []*mispTagType) []string {
+	names := []string{}
+	for _, tag := range tags {
+		if tag.Name != "" {
+			names = append(names, tag.Name)
+		}
+	}
+	return names
+}
+
+// ruleSource returns the body of a rule preceded by the imports of the
+// modules it uses and the rules it refers to, so it compiles on its own.
+func ruleSource(doc *yaraRuleType, deps []*yaraRuleType) string {
+	var source strings.Builder
+	modules := MapSet{}
+	for _, rule := range append(deps, doc) {
+		modules.AddFromSlice(rule.Modules)
+	}
+	for _, module := range sortedKeys(modules) {
+		fmt.Fprintf(&source, "import \"%s\"\n", module)
+	}
+	if len(modules) > 0 {
+		source.WriteString("\n")
+	}
+	for _, dep := range deps {
+		source.WriteString(strings.TrimSpace(dep.Body) + "\n\n")
+	}
+	source.WriteString(strings.TrimSpace(doc.Body))
+	return source.String()
+}
+
+// writeMISPEvent writes rules as a MISP event with one yara attribute per
+// rule. The rule and user tags and the license become attribute tags, and
+// the description metadata and the attribution the attribute comment.
+func writeMISPEvent(out io.Writer, docs []*yaraRuleType, info string, deps map[string][]*yaraRuleType) error {
+	// The event is dated by its most recently changed rule, so exporting the
+	// same rules twice gives the same event
+	date := stixEpoch
+	for _, doc := range docs {
+		if _, modified := ruleTimes(doc); modified > date {
+			date = modified
+		}
+	}
+	event := &mispEventType{
+		Info:          info,
+		Date:          date[:len("2006-01-02")],
+		ThreatLevelID: "4",
+		Analysis:      "2",
+		Distribution:  "0",
+		Attributes:    []*mispAttributeType{},
+	}
+	ids := []string{}
+	for _, doc := range docs {
+		tags := MapSet{}
+		tags.AddFromSlice(doc.RuleTags)
+		tags.AddFromSlice(doc.UserTags)
+		if doc.License != "" {
+			tags.Add("license:" + doc.License)
+		}
+		comment := strings.Join(doc.Metadata["description"], "\n")
+		event.Attributes = append(event.Attributes, &mispAttributeType{
+			UUID:     nameUUID("misp-attribute:" + doc.ID),
+			Type:     "yara",
+			Category: mispYaraCategory,
+			ToIDS:    true,
+			Value:    ruleSource(doc, deps[doc.ID]),
+			Comment:  strings.TrimSpace(comment + "\n" + ruleAttribution(doc)),
+			Tags:     mispTags(sortedKeys(tags)),
+		})
+		ids = append(ids, doc.ID)
+	}
+	sort.Strings(ids)
+	event.UUID = nameUUID("misp-event:" + info + ":" + strings.Join(ids, ","))
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&mispEventFileType{Event: event})
+}
+
+// readMISPEvent reads a MISP event JSON file, with or without the
+// enclosing Event object.
+func readMISPEvent(filename string) (*mispEventType, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	file := &mispEventFileType{}
+	err = json.Unmarshal(data, file)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a MISP event: %v", filename, err)
+	}
+	if file.Event != nil {
+		return file.Event, nil
+	}
+	event := &mispEventType{}
+	err = json.Unmarshal(data, event)
+	if err != nil || (event.UUID == "" && event.Info == "" && len(event.Attributes) == 0) {
+		return nil, fmt.Errorf("%s is not a MISP event", filename)
+	}
+	return event, nil
+}
+
+// importMISPEvent imports the yara attributes of a MISP event, each as a
+// ruleset named after the event and attribute. The rules record the event
+// they came from and get the event and attribute tags as user tags.
+func importMISPEvent(ctx *YaramanContext, filename string) error {
+	event, err := readMISPEvent(filename)
+	if err != nil {
+		return err
+	}
+	attributes := append([]*mispAttributeType{}, event.Attributes...)
+	for _, object := range event.Objects {
+		attributes = append(attributes, object.Attributes...)
+	}
+	eventID := event.UUID
+	if eventID == "" {
+		eventID = nameUUID("misp-event:" + filename)
+	}
+
+	defer func() {
+		ctx.misp = nil
+	}()
+	found := 0
+	for n, attribute := range attributes {
+		if attribute.Type != "yara" {
+			continue
+		}
+		found++
+		attributeID := attribute.UUID
+		if attributeID == "" {
+			attributeID = fmt.Sprintf("%d", n)
+		}
+		rulesetName := fmt.Sprintf("misp/%s/%s", eventID, attributeID)
+		ctx.misp = &mispSourceType{
+			event: event,
+			tags:  append(mispTagNames(event.Tags), mispTagNames(attribute.Tags)...),
+		}
+		rules, err := parseRuleset(ctx, rulesetName, strings.NewReader(attribute.Value), makeRulesetDoc, makeRuleDoc)
+		if err != nil {
+			errorLogger.Error().AnErr("error", err).Str("filename", filename).Str("attribute", attributeID).Msg("Error parsing yara attribute")
+		}
+		if ctx.report == nil {
+			continue
+		}
+		ctx.report.addFile(rulesetName, rules, err)
+		if err == nil {
+			err = ctx.report.finishRuleset(ctx, rulesetName)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if found == 0 {
+		logger.Warn().Str("filename", filename).Msg("MISP event has no yara attributes")
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/VirusTotal/gyp"
+	"github.com/VirusTotal/gyp/ast"
+)
+
+// parseYara parses YARA source with gyp. gyp v0.4.2 drops the range of an
+// xor modifier and the alphabet of a base64 modifier when another
+// modifier follows them, as in xor(1-3) ascii, so these are read again
+// from the source.
+func parseYara(source string) (*ast.RuleSet, error) {
+	ruleset, err := gyp.ParseString(source)
+	if err != nil {
+		return nil, err
+	}
+	texts := []*ast.TextString{}
+	needed := false
+	for _, rule := range ruleset.Rules {
+		for _, s := range rule.Strings {
+			text, _ := s.(*ast.TextString)
+			texts = append(texts, text)
+			if text != nil && (text.Xor || text.Base64 || text.Base64Wide) {
+				needed = true
+			}
+		}
+	}
+	if !needed {
+		return ruleset, nil
+	}
+	defs, err := stringModifierArgs(source)
+	if err == nil && len(defs) != len(texts) {
+		err = fmt.Errorf("found %d string definitions, expected %d", len(defs), len(texts))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read string modifiers: %v", err)
+	}
+	for i, text := range texts {
+		if text == nil {
+			continue
+		}
+		err = setModifierArgs(text, defs[i])
+		if err != nil {
+			return nil, fmt.Errorf("string $%s: %v", text.Identifier, err)
+		}
+	}
+	return ruleset, nil
+}
+
+// setModifierArgs sets the xor range and base64 alphabet of a text string
+// from the arguments of its modifiers.
+func setModifierArgs(text *ast.TextString, args map[string]string) error {
+	xor, ok := args["xor"]
+	if ok != text.Xor {
+		return fmt.Errorf("xor modifier not found in the source")
+	}
+	if ok {
+		min, max, err := parseXorRange(xor)
+		if err != nil {
+			return err
+		}
+		text.XorMin, text.XorMax = min, max
+	}
+	for _, name := range []string{"base64", "base64wide"} {
+		if alphabet := args[name]; alphabet != "" {
+			text.Base64Alphabet = strings.TrimSuffix(strings.TrimPrefix(alphabet, `"`), `"`)
+		}
+	}
+	return nil
+}
+
+// parseXorRange parses the argument of an xor modifier: nothing, a key or
+// a range of keys.
+func parseXorRange(arg string) (int32, int32, error) {
+	if arg == "" {
+		return 0, 255, nil
+	}
+	bounds := strings.SplitN(arg, "-", 2)
+	keys := []int32{}
+	for _, bound := range bounds {
+		key, err := strconv.ParseInt(strings.TrimSpace(bound), 0, 32)
+		if err != nil || key < 0 || key > 255 {
+			return 0, 0, fmt.Errorf("invalid xor argument %q", arg)
+		}
+		keys = append(keys, int32(key))
+	}
+	return keys[0], keys[len(keys)-1], nil
+}
+
+// modifierScannerType reads the string definitions of YARA source without
+// a full parser. It only needs to get past comments, quoted strings and
+// regular expressions to find the strings sections.
+type modifierScannerType struct {
+	source string
+	pos    int
+}
+
+// stringModifierArgs returns the modifiers of each string definition in
+// source, in order, mapped to their arguments as written.
+func stringModifierArgs(source string) ([]map[string]string, error) {
+	scanner := &modifierScannerType{source: source}
+	defs := []map[string]string{}
+	previous := ""
+	for {
+		scanner.skipSpace()
+		if scanner.done() {
+			return defs, nil
+		}
+		c := scanner.source[scanner.pos]
+		switch {
+		case c == '"':
+			scanner.skipQuoted('"')
+			previous = ""
+		case c == '/' && previous == "matches":
+			scanner.skipRegex()
+			previous = ""
+		case isIdentifierChar(c):
+			previous = scanner.word()
+			scanner.skipSpace()
+			if previous == "strings" && scanner.peek() == ':' {
+				scanner.pos++
+				sectionDefs, err := scanner.stringsSection()
+				if err != nil {
+					return nil, err
+				}
+				defs = append(defs, sectionDefs...)
+			}
+		default:
+			scanner.pos++
+			previous = ""
+		}
+	}
+}
+
+// stringsSection reads string definitions up to the condition section.
+func (scanner *modifierScannerType) stringsSection() ([]map[string]string, error) {
+	defs := []map[string]string{}
+	for {
+		scanner.skipSpace()
+		if scanner.peek() != '$' {
+			if scanner.peekWord() == "condition" {
+				return defs, nil
+			}
+			return nil, scanner.errorf("expected string definition")
+		}
+		scanner.pos++
+		scanner.word()
+		scanner.skipSpace()
+		if scanner.peek() != '=' {
+			return nil, scanner.errorf("expected =")
+		}
+		scanner.pos++
+		scanner.skipSpace()
+		switch scanner.peek() {
+		case '"':
+			scanner.skipQuoted('"')
+		case '{':
+			scanner.skipHex()
+		case '/':
+			scanner.skipRegex()
+			scanner.word()
+		default:
+			return nil, scanner.errorf("expected string value")
+		}
+		args := map[string]string{}
+		for {
+			scanner.skipSpace()
+			if scanner.peek() == '$' || scanner.peekWord() == "condition" || scanner.peekWord() == "" {
+				break
+			}
+			name := scanner.word()
+			args[name] = ""
+			scanner.skipSpace()
+			if scanner.peek() == '(' {
+				arg, err := scanner.parenthesized()
+				if err != nil {
+					return nil, err
+				}
+				args[name] = arg
+			}
+		}
+		defs = append(defs, args)
+	}
+}
+
+func (scanner *modifierScannerType) done() bool {
+	return scanner.pos >= len(scanner.source)
+}
+
+func (scanner *modifierScannerType) peek() byte {
+	if scanner.done() {
+		return 0
+	}
+	return scanner.source[scanner.pos]
+}
+
+func (scanner *modifierScannerType) errorf(format string, args ...interface{}) error {
+	line := strings.Count(scanner.source[:scanner.pos], "\n") + 1
+	return fmt.Errorf("line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func (scanner *modifierScannerType) peekWord() string {
+	end := scanner.pos
+	for end < len(scanner.source) && isIdentifierChar(scanner.source[end]) {
+		end++
+	}
+	return scanner.source[scanner.pos:end]
+}
+
+func (scanner *modifierScannerType) word() string {
+	word := scanner.peekWord()
+	scanner.pos += len(word)
+	return word
+}
+
+// skipSpace skips whitespace and comments.
+func (scanner *modifierScannerType) skipSpace() {
+	for !scanner.done() {
+		rest := scanner.source[scanner.pos:]
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			end := strings.IndexByte(rest, '\n')
+			if end < 0 {
+				end = len(rest)
+			}
+			scanner.pos += end
+		case strings.HasPrefix(rest, "/*"):
+			end := strings.Index(rest[2:], "*/")
+			if end < 0 {
+				scanner.pos = len(scanner.source)
+			} else {
+				scanner.pos += end + 4
+			}
+		case strings.ContainsRune(" \t\r\n", rune(rest[0])):
+			scanner.pos++
+		default:
+			return
+		}
+	}
+}
+
+// skipQuoted skips a string or regular expression ending at an unescaped
+// delimiter.
+func (scanner *modifierScannerType) skipQuoted(delimiter byte) {
+	for scanner.pos++; !scanner.done(); scanner.pos++ {
+		switch scanner.source[scanner.pos] {
+		case '\\':
+			scanner.pos++
+		case delimiter:
+			scanner.pos++
+			return
+		}
+	}
+}
+
+func (scanner *modifierScannerType) skipRegex() {
+	scanner.skipQuoted('/')
+}
+
+func (scanner *modifierScannerType) skipHex() {
+	end := strings.IndexByte(scanner.source[scanner.pos:], '}')
+	if end < 0 {
+		scanner.pos = len(scanner.source)
+		return
+	}
+	scanner.pos += end + 1
+}
+
+// parenthesized returns the trimmed text between parentheses.
+func (scanner *modifierScannerType) parenthesized() (string, error) {
+	start := scanner.pos + 1
+	for scanner.pos++; !scanner.done(); scanner.pos++ {
+		switch scanner.source[scanner.pos] {
+		case '"':
+			scanner.skipQuoted('"')
+			scanner.pos--
+		case ')':
+			scanner.pos++
+			return strings.TrimSpace(scanner.source[start : scanner.pos-1]), nil
+		}
+	}
+	return "", scanner.errorf("unterminated modifier argument")
+}
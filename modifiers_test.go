@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/VirusTotal/gyp/ast"
+)
+
+func TestParseYaraModifiers(t *testing.T) {
+	source := `
+rule a {
+  meta:
+    description = "strings: $x = \"y\" xor(9)"
+  strings:
+    $a = "a\"b" xor(1-3) ascii // xor(7)
+    $b = { 61 62 } private
+    $c = /a\/b/is wide
+    $d = "cd" /* xor(8) */ xor wide
+    $e = "ef" base64("!@#$%^&*(){}[].,|ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstu") wide
+  condition:
+    any of them and "x" matches /strings: $y = "z"/
+}
+
+rule b {
+  strings:
+    $ = "gh" xor(0x10 - 0x20) nocase
+  condition:
+    all of them
+}
+`
+	ruleset, err := parseYara(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		s        ast.String
+		min, max int32
+	}{
+		{ruleset.Rules[0].Strings[0], 1, 3},
+		{ruleset.Rules[0].Strings[3], 0, 255},
+		{ruleset.Rules[1].Strings[0], 16, 32},
+	}
+	for _, test := range tests {
+		text := test.s.(*ast.TextString)
+		if !text.Xor || text.XorMin != test.min || text.XorMax != test.max {
+			t.Errorf("$%s: expected xor(%d-%d), found xor(%d-%d)", text.Identifier, test.min, test.max, text.XorMin, text.XorMax)
+		}
+	}
+	if alphabet := ruleset.Rules[0].Strings[4].(*ast.TextString).Base64Alphabet; len(alphabet) != 64 {
+		t.Errorf("base64 alphabet not read from the source: %q", alphabet)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/VirusTotal/gyp/ast"
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+	toml "github.com/pelletier/go-toml"
+)
+
+// Checks making up the quality score of a rule
+const (
+	qualityDescription = "description"
+	qualityAuthor      = "author"
+	qualityReference   = "reference"
+	qualityDate        = "date"
+	qualityHash        = "hash"
+	qualityStrings     = "strings"
+	qualityPerformance = "performance"
+)
+
+// Strings shorter than this many bytes make poor atoms and slow scanning
+const minStringBytes = 4
+
+var (
+	qualityChecks = []string{qualityDescription, qualityAuthor, qualityReference, qualityDate, qualityHash, qualityStrings, qualityPerformance}
+
+	// Weight of each check, set with a [quality] section in yaraman.toml like
+	//
+	//	[quality]
+	//	hash = 0
+	//	performance = 30
+	//
+	// Scores are computed when rules are imported, so rules must be
+	// imported again for new weights to apply.
+	qualityWeights = defaultQualityWeights()
+
+	hexJumpRE = regexp.MustCompile(`\[[^\]]*\]`)
+	hexByteRE = regexp.MustCompile(`[0-9A-Fa-f]{2}`)
+)
+
+func defaultQualityWeights() map[string]int {
+	return map[string]int{
+		qualityDescription: 15,
+		qualityAuthor:      10,
+		qualityReference:   10,
+		qualityDate:        10,
+		qualityHash:        15,
+		qualityStrings:     20,
+		qualityPerformance: 20,
+	}
+}
+
+// loadQualityWeights reads the [quality] section of the configuration.
+// Checks that are not in it keep their default weight.
+func loadQualityWeights(config *toml.Tree) (map[string]int, error) {
+	weights := defaultQualityWeights()
+	tree, ok := config.Get("quality").(*toml.Tree)
+	if !ok {
+		if config.Has("quality") {
+			return nil, fmt.Errorf("quality must be a table of weights")
+		}
+		return weights, nil
+	}
+	for _, check := range tree.Keys() {
+		if _, ok := weights[check]; !ok {
+			return nil, fmt.Errorf("unknown quality check %s, expected one of %s", check, strings.Join(qualityChecks, ", "))
+		}
+		weight, ok := tree.Get(check).(int64)
+		if !ok || weight < 0 {
+			return nil, fmt.Errorf("weight of quality check %s must be a number of at least 0", check)
+		}
+		weights[check] = int(weight)
+	}
+	return weights, nil
+}
+
+func hasMetadata(doc *yaraRuleType, field string) bool {
+	for _, value := range doc.Metadata[field] {
+		if strings.TrimSpace(value) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasHashMetadata reports whether the rule names a sample by its hash.
+func hasHashMetadata(doc *yaraRuleType) bool {
+	for key := range doc.Metadata {
+		for _, name := range []string{"hash", "md5", "sha1", "sha256"} {
+			if strings.Contains(key, name) && hasMetadata(doc, key) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// saneStrings reports whether the rule matches on strings or modules and
+// has no duplicate string definitions.
+func saneStrings(doc *yaraRuleType) bool {
+	if len(doc.Strings) == 0 && len(doc.Modules) == 0 {
+		return false
+	}
+	values := MapSet{}
+	for _, s := range doc.Strings {
+		key := s.Type + ":" + s.Value + ":" + strings.Join(s.Modifiers, " ")
+		if s.Value == "" || values.Contains(key) {
+			return false
+		}
+		values.Add(key)
+	}
+	return true
+}
+
+// stringBytes returns the number of fixed bytes of a text or hex string.
+func stringBytes(s *stringDefType) int {
+	switch s.Type {
+	case "text":
+		return len((&ast.TextString{Value: s.Value}).UnescapedValue())
+	case "hex":
+		return len(hexByteRE.FindAllString(hexJumpRE.ReplaceAllString(strings.ReplaceAll(s.Value, " ", ""), ""), -1))
+	}
+	return minStringBytes
+}
+
+// performant reports whether the strings of the rule avoid short atoms and
+// unbounded regular expressions.
+func performant(doc *yaraRuleType) bool {
+	for _, s := range doc.Strings {
+		if stringBytes(s) < minStringBytes {
+			return false
+		}
+		if s.Type == "regex" && (strings.Contains(s.Value, ".*") || strings.Contains(s.Value, ".+")) {
+			return false
+		}
+	}
+	return true
+}
+
+// scoreRule returns the quality score of a rule, from 0 to 100, and the
+// checks it fails. Checks with a weight of 0 are skipped.
+func scoreRule(doc *yaraRuleType) (int, []string) {
+	passed := map[string]bool{
+		qualityDescription: hasMetadata(doc, "description"),
+		qualityAuthor:      hasMetadata(doc, "author"),
+		qualityReference:   hasMetadata(doc, "reference"),
+		qualityDate:        doc.CreationDate != "" || doc.LastModified != "" || doc.ReleaseDate != "",
+		qualityHash:        hasHashMetadata(doc),
+		qualityStrings:     saneStrings(doc),
+		qualityPerformance: performant(doc),
+	}
+	total, earned := 0, 0
+	issues := []string{}
+	for _, check := range qualityChecks {
+		weight := qualityWeights[check]
+		if weight == 0 {
+			continue
+		}
+		total += weight
+		if passed[check] {
+			earned += weight
+		} else {
+			issues = append(issues, check)
+		}
+	}
+	if total == 0 {
+		return 100, issues
+	}
+	return (earned*100 + total/2) / total, issues
+}
+
+// minQualityQuery matches rules with a quality score of at least min.
+func minQualityQuery(min int) query.Query {
+	lower := float64(min)
+	inclusive := true
+	qualityQuery := bleve.NewNumericRangeInclusiveQuery(&lower, nil, &inclusive, nil)
+	qualityQuery.SetField("quality")
+	return qualityQuery
+}
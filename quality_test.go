@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestStringBytes(t *testing.T) {
+	tests := []struct {
+		def   stringDefType
+		bytes int
+	}{
+		{stringDefType{Type: "text", Value: `abc`}, 3},
+		{stringDefType{Type: "text", Value: `\x41\x42\"\\`}, 4},
+		{stringDefType{Type: "hex", Value: `4D 5A [2-4] 90 ?? 00`}, 4},
+		{stringDefType{Type: "regex", Value: `a.*b`}, minStringBytes},
+	}
+	for _, test := range tests {
+		if n := stringBytes(&test.def); n != test.bytes {
+			t.Errorf("%s: expected %d bytes, found %d", test.def.Value, test.bytes, n)
+		}
+	}
+}
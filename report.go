@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	gyperror "github.com/VirusTotal/gyp/error"
+)
+
+const (
+	importSequenceKey = "import_seq"
+	importReportKey   = "import:%08d"
+	importUndoKey     = "import:%08d:undo"
+)
+
+// Parse error reported by gyp. gyp only tracks line numbers, so the line
+// is the most precise location available.
+type parseErrorType struct {
+	Line    int    `json:"line,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// Result of importing a single ruleset file.
+type importFileType struct {
+	Filename string          `json:"filename"`
+	Rules    int             `json:"rules"`
+	Error    *parseErrorType `json:"error,omitempty"`
+}
+
+// Report built for every import and stored in the database so it can be
+// shown later with the history command. Each import is a numbered
+// transaction that can be rolled back.
+type importReportType struct {
+	ID         int               `json:"id"`
+	SourceType string            `json:"source_type"`
+	Source     string            `json:"source"`
+	Commit     string            `json:"commit,omitempty"`
+	Started    time.Time         `json:"started"`
+	Finished   time.Time         `json:"finished"`
+	RuleCount  int               `json:"rule_count"`
+	ErrorCount int               `json:"error_count"`
+	Files      []*importFileType `json:"files"`
+	Added      []string          `json:"added"`
+	Changed    []string          `json:"changed"`
+	Removed    []string          `json:"removed"`
+	RolledBack *time.Time        `json:"rolled_back,omitempty"`
+	// Why the import stopped before all files were imported
+	Aborted string `json:"aborted,omitempty"`
+
+	// Rule IDs seen per ruleset during the import
+	seen map[string][]string
+	undo *importUndoType
+}
+
+// Everything needed to restore the index to its state before an import.
+type importUndoType struct {
+	// Previous versions of changed and removed rules
+	Rules []*yaraRuleType `json:"rules"`
+	// Previous values of the internal lists modified by the import. A nil
+	// list means the key did not exist.
+	Lists map[string][]string `json:"lists"`
+	// Previous versions of the rules whose git history was indexed, empty
+	// if they had none
+	Versions map[string][]*ruleVersionType `json:"versions,omitempty"`
+}
+
+func newImportReport(sourceType string, source string) *importReportType {
+	return &importReportType{
+		SourceType: sourceType,
+		Source:     source,
+		Started:    time.Now(),
+		Files:      []*importFileType{},
+		Added:      []string{},
+		Changed:    []string{},
+		Removed:    []string{},
+		seen:       map[string][]string{},
+		undo: &importUndoType{
+			Rules:    []*yaraRuleType{},
+			Lists:    map[string][]string{},
+			Versions: map[string][]*ruleVersionType{},
+		},
+	}
+}
+
+func makeParseError(err error) *parseErrorType {
+	if yaraError, ok := err.(gyperror.Error); ok {
+		return &parseErrorType{
+			Line:    yaraError.Line,
+			Code:    int(yaraError.Code),
+			Message: yaraError.Message,
+		}
+	}
+	return &parseErrorType{Message: err.Error()}
+}
+
+// addFile records the outcome of importing one file.
+func (report *importReportType) addFile(filename string, rules int, err error) {
+	file := &importFileType{
+		Filename: filename,
+		Rules:    rules,
+	}
+	if err != nil {
+		file.Error = makeParseError(err)
+		report.ErrorCount++
+	}
+	report.RuleCount += rules
+	report.Files = append(report.Files, file)
+}
+
+func (report *importReportType) writeSummary(out io.Writer) {
+	status := ""
+	if report.Aborted != "" {
+		status = "  (aborted)"
+	}
+	if report.RolledBack != nil {
+		status = "  (rolled back)"
+	}
+	fmt.Fprintf(out, "%4d  %s  %-6s %s  files: %d  rules: %d  errors: %d  +%d ~%d -%d%s\n",
+		report.ID, report.Started.Format("2006-01-02 15:04:05"), report.SourceType, report.Source,
+		len(report.Files), report.RuleCount, report.ErrorCount,
+		len(report.Added), len(report.Changed), len(report.Removed), status)
+}
+
+func (report *importReportType) writeText(out io.Writer) {
+	fmt.Fprintf(out, "Import %d from %s %s\n", report.ID, report.SourceType, report.Source)
+	if report.Commit != "" {
+		fmt.Fprintf(out, "Commit:   %s\n", report.Commit)
+	}
+	fmt.Fprintf(out, "Started:  %s\n", report.Started.Format(time.RFC3339))
+	fmt.Fprintf(out, "Finished: %s\n", report.Finished.Format(time.RFC3339))
+	if report.RolledBack != nil {
+		fmt.Fprintf(out, "Rolled back: %s\n", report.RolledBack.Format(time.RFC3339))
+	}
+	if report.Aborted != "" {
+		fmt.Fprintf(out, "Aborted:  %s\n", report.Aborted)
+	}
+	fmt.Fprintf(out, "Files: %d  Rules: %d  Errors: %d\n", len(report.Files), report.RuleCount, report.ErrorCount)
+	fmt.Fprintf(out, "Added: %d  Changed: %d  Removed: %d\n", len(report.Added), len(report.Changed), len(report.Removed))
+	for _, file := range report.Files {
+		if file.Error == nil {
+			fmt.Fprintf(out, "  ok     %5d  %s\n", file.Rules, file.Filename)
+			continue
+		}
+		if file.Error.Line > 0 {
+			fmt.Fprintf(out, "  error  %5d  %s:%d: %s\n", file.Rules, file.Filename, file.Error.Line, file.Error.Message)
+		} else {
+			fmt.Fprintf(out, "  error  %5d  %s: %s\n", file.Rules, file.Filename, file.Error.Message)
+		}
+	}
+	for _, id := range report.Added {
+		fmt.Fprintf(out, "  added    %s\n", id)
+	}
+	for _, id := range report.Changed {
+		fmt.Fprintf(out, "  changed  %s\n", id)
+	}
+	for _, id := range report.Removed {
+		fmt.Fprintf(out, "  removed  %s\n", id)
+	}
+}
+
+func (report *importReportType) writeJSON(out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+// saveImportReport stores the report in the database. New reports are
+// assigned the next import number and their undo information is saved
+// with them.
+func saveImportReport(ctx *YaramanContext, report *importReportType) error {
+	if report.ID != 0 {
+		return saveInternal(ctx, fmt.Sprintf(importReportKey, report.ID), report)
+	}
+
+	lastID := 0
+	_, err := loadInternal(ctx, importSequenceKey, &lastID)
+	if err != nil {
+		return err
+	}
+	report.ID = lastID + 1
+	err = saveInternal(ctx, fmt.Sprintf(importUndoKey, report.ID), report.undo)
+	if err != nil {
+		return err
+	}
+	err = saveInternal(ctx, fmt.Sprintf(importReportKey, report.ID), report)
+	if err != nil {
+		return err
+	}
+	return saveInternal(ctx, importSequenceKey, report.ID)
+}
+
+// finishImport completes the import in progress. Rules that are no longer
+// in the source are removed, the git history of the rules is indexed if
+// requested and the report is saved.
+func finishImport(ctx *YaramanContext, indexHistory bool) (*importReportType, error) {
+	report := ctx.report
+	err := report.finishSource(ctx)
+	ctx.report = nil
+	if err != nil {
+		return nil, err
+	}
+	err = flushBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Finished = time.Now()
+	if report.Commit != "" && indexHistory {
+		err = indexRuleVersions(ctx, report)
+		if err != nil {
+			errorLogger.Error().AnErr("error", err).Msg("Could not index rule versions.")
+		}
+	}
+	err = saveImportReport(ctx, report)
+	if err != nil {
+		errorLogger.Error().AnErr("error", err).Msg("Could not save import report.")
+	}
+	return report, nil
+}
+
+// abortImport saves the import in progress after it failed with cause, so
+// the rules it already indexed can be rolled back. Rules of rulesets that
+// were not reached are kept.
+func abortImport(ctx *YaramanContext, cause error) error {
+	report := ctx.report
+	ctx.report = nil
+	if len(report.Files) == 0 {
+		return cause
+	}
+	report.Aborted = cause.Error()
+	err := flushBatch(ctx)
+	if err != nil {
+		return err
+	}
+	report.Finished = time.Now()
+	err = saveImportReport(ctx, report)
+	if err != nil {
+		errorLogger.Error().AnErr("error", err).Msg("Could not save import report.")
+		return cause
+	}
+	return fmt.Errorf("import %d aborted, roll it back with rollback %d: %v", report.ID, report.ID, cause)
+}
+
+func loadImportReport(ctx *YaramanContext, id int) (*importReportType, error) {
+	report := &importReportType{}
+	found, err := loadInternal(ctx, fmt.Sprintf(importReportKey, id), report)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("import %d not found", id)
+	}
+	return report, nil
+}
+
+// loadImportReports returns all stored import reports, oldest first.
+func loadImportReports(ctx *YaramanContext) ([]*importReportType, error) {
+	lastID := 0
+	_, err := loadInternal(ctx, importSequenceKey, &lastID)
+	if err != nil {
+		return nil, err
+	}
+	reports := []*importReportType{}
+	for id := 1; id <= lastID; id++ {
+		report := &importReportType{}
+		found, err := loadInternal(ctx, fmt.Sprintf(importReportKey, id), report)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			reports = append(reports, report)
+		}
+	}
+	return reports, nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Internal key holding the samples added to a rule with "samples add"
+const ruleSamplesKey = "samples:%s"
+
+// MD5, SHA1 or SHA256 hashes in hash metadata
+var sampleHashRE = regexp.MustCompile(`\b([0-9a-fA-F]{64}|[0-9a-fA-F]{40}|[0-9a-fA-F]{32})\b`)
+
+func isSampleHash(s string) bool {
+	return sampleHashRE.FindString(s) == s
+}
+
+// ruleSamples returns the samples a rule is expected to match: the hashes
+// in its hash metadata and the hashes or paths added to it.
+func ruleSamples(ctx *YaramanContext, doc *yaraRuleType) ([]string, error) {
+	samples := []string{}
+	for _, value := range doc.Metadata["hash"] {
+		for _, hash := range sampleHashRE.FindAllString(value, -1) {
+			hash = strings.ToLower(hash)
+			if !containsString(samples, hash) {
+				samples = append(samples, hash)
+			}
+		}
+	}
+	added, err := loadList(ctx, fmt.Sprintf(ruleSamplesKey, doc.ID))
+	if err != nil {
+		return nil, err
+	}
+	for _, sample := range added {
+		if !containsString(samples, sample) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples, nil
+}
+
+// normalizeSample lower cases hashes and makes paths absolute.
+func normalizeSample(sample string) (string, error) {
+	if isSampleHash(sample) {
+		return strings.ToLower(sample), nil
+	}
+	if !fileExists(sample) {
+		return "", fmt.Errorf("%s is neither a hash nor an existing file", sample)
+	}
+	return absolutePath(sample)
+}
+
+func absolutePath(path string) (string, error) {
+	if strings.HasPrefix(path, string(os.PathSeparator)) {
+		return path, nil
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return makeFullPath(dir, path), nil
+}
+
+// addRuleSamples associates samples with a rule, or removes them if
+// remove is set.
+func addRuleSamples(ctx *YaramanContext, id string, samples []string, remove bool) error {
+	key := fmt.Sprintf(ruleSamplesKey, id)
+	list, err := loadList(ctx, key)
+	if err != nil {
+		return err
+	}
+	for _, sample := range samples {
+		normalized, err := normalizeSample(sample)
+		if err != nil {
+			if !remove {
+				return err
+			}
+			// Files deleted since they were added are removed by path
+			normalized, err = absolutePath(sample)
+			if err != nil {
+				return err
+			}
+		}
+		sample = normalized
+		if remove {
+			list = removeString(list, sample)
+		} else if !containsString(list, sample) {
+			list = append(list, sample)
+		}
+	}
+	err = setList(ctx, key, list)
+	if err != nil {
+		return err
+	}
+	return flushBatch(ctx)
+}
+
+func removeString(list []string, s string) []string {
+	result := []string{}
+	for _, item := range list {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// Files of the samples directory by MD5, SHA1 and SHA256 hash, built
+// when a hash is first looked up.
+type sampleStoreType struct {
+	dir    string
+	byHash map[string]string
+}
+
+func newSampleStore(dir string) *sampleStoreType {
+	return &sampleStoreType{dir: dir}
+}
+
+func hashFile(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	md5Hash, sha1Hash, sha256Hash := md5.New(), sha1.New(), sha256.New()
+	_, err = io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), file)
+	if err != nil {
+		return nil, err
+	}
+	return []string{
+		hex.EncodeToString(md5Hash.Sum(nil)),
+		hex.EncodeToString(sha1Hash.Sum(nil)),
+		hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
+
+func (store *sampleStoreType) load(ctx *YaramanContext) error {
+	store.byHash = map[string]string{}
+	if !dirExists(store.dir) {
+		logger.Info().Str("directory", store.dir).Msg("Samples directory does not exist.")
+		return nil
+	}
+	return findFiles(ctx, store.dir, true, func(ctx *YaramanContext, filename string) error {
+		hashes, err := hashFile(filename)
+		if err != nil {
+			errorLogger.Error().Err(err).Str("filename", filename).Msg("Could not hash sample.")
+			return nil
+		}
+		for _, hash := range hashes {
+			store.byHash[hash] = filename
+		}
+		return nil
+	})
+}
+
+// resolve returns the file of a sample given by path or hash.
+func (store *sampleStoreType) resolve(ctx *YaramanContext, sample string) (string, error) {
+	if !isSampleHash(sample) {
+		if !fileExists(sample) {
+			return "", fmt.Errorf("sample %s does not exist", sample)
+		}
+		return sample, nil
+	}
+	if store.byHash == nil {
+		err := store.load(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+	filename, ok := store.byHash[sample]
+	if !ok {
+		return "", fmt.Errorf("sample %s not found in %s", sample, store.dir)
+	}
+	return filename, nil
+}
+
+// Outcome of testing a rule against one of its samples.
+type sampleTestType struct {
+	ID      string  `json:"id"`
+	Ruleset string  `json:"ruleset"`
+	Rule    string  `json:"rule"`
+	Sample  string  `json:"sample"`
+	File    string  `json:"file,omitempty"`
+	Status  string  `json:"status"`
+	Message string  `json:"message,omitempty"`
+	Time    float64 `json:"time"`
+}
+
+const (
+	testPassed  = "passed"
+	testFailed  = "failed"
+	testError   = "error"
+	testSkipped = "skipped"
+)
+
+// runSampleTests checks that every rule matches each of its samples.
+// Rules without samples are not tested.
+func runSampleTests(ctx *YaramanContext, docs []*yaraRuleType, store *sampleStoreType) ([]*sampleTestType, error) {
+	scanner, err := newScanner(ctx, docs)
+	if err != nil {
+		return nil, err
+	}
+	unsupportedRules := map[string]*unsupportedRuleType{}
+	for _, rule := range scanner.unsupportedRules() {
+		unsupportedRules[rule.ID] = rule
+	}
+
+	tests := []*sampleTestType{}
+	for i, doc := range docs {
+		samples, err := ruleSamples(ctx, doc)
+		if err != nil {
+			return nil, err
+		}
+		for _, sample := range samples {
+			test := &sampleTestType{ID: doc.ID, Ruleset: doc.RulesetName, Rule: doc.RuleName, Sample: sample}
+			tests = append(tests, test)
+			if rule, ok := unsupportedRules[doc.ID]; ok {
+				test.Status = testSkipped
+				test.Message = strings.Join(rule.Reasons, ", ")
+				continue
+			}
+			test.File, err = store.resolve(ctx, sample)
+			if err != nil {
+				test.Status = testError
+				test.Message = err.Error()
+				continue
+			}
+			data, err := ioutil.ReadFile(test.File)
+			if err != nil {
+				test.Status = testError
+				test.Message = err.Error()
+				continue
+			}
+			start := time.Now()
+			matched, err := scanner.matchRule(scanner.selected[i], data)
+			test.Time = time.Since(start).Seconds()
+			switch {
+			case err != nil:
+				test.Status = testError
+				test.Message = err.Error()
+			case matched:
+				test.Status = testPassed
+			default:
+				test.Status = testFailed
+				test.Message = "rule did not match the sample"
+			}
+		}
+	}
+	return tests, nil
+}
+
+func countTests(tests []*sampleTestType, status string) int {
+	count := 0
+	for _, test := range tests {
+		if test.Status == status {
+			count++
+		}
+	}
+	return count
+}
+
+type junitFailureType struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitSkippedType struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitTestCaseType struct {
+	Name      string            `xml:"name,attr"`
+	ClassName string            `xml:"classname,attr"`
+	Time      string            `xml:"time,attr"`
+	Failure   *junitFailureType `xml:"failure,omitempty"`
+	Error     *junitFailureType `xml:"error,omitempty"`
+	Skipped   *junitSkippedType `xml:"skipped,omitempty"`
+}
+
+type junitTestSuiteType struct {
+	Name      string               `xml:"name,attr"`
+	Tests     int                  `xml:"tests,attr"`
+	Failures  int                  `xml:"failures,attr"`
+	Errors    int                  `xml:"errors,attr"`
+	Skipped   int                  `xml:"skipped,attr"`
+	TestCases []*junitTestCaseType `xml:"testcase"`
+}
+
+type junitTestSuitesType struct {
+	XMLName    xml.Name              `xml:"testsuites"`
+	TestSuites []*junitTestSuiteType `xml:"testsuite"`
+}
+
+// writeJUnit writes the tests as a JUnit XML report with one test suite
+// per ruleset.
+func writeJUnit(out io.Writer, tests []*sampleTestType) error {
+	suites := map[string]*junitTestSuiteType{}
+	names := []string{}
+	for _, test := range tests {
+		suite, ok := suites[test.Ruleset]
+		if !ok {
+			suite = &junitTestSuiteType{Name: test.Ruleset}
+			suites[test.Ruleset] = suite
+			names = append(names, test.Ruleset)
+		}
+		testCase := &junitTestCaseType{
+			Name:      test.Rule + " " + test.Sample,
+			ClassName: test.Ruleset,
+			Time:      fmt.Sprintf("%.3f", test.Time),
+		}
+		suite.Tests++
+		switch test.Status {
+		case testFailed:
+			suite.Failures++
+			testCase.Failure = &junitFailureType{Message: test.Message}
+		case testError:
+			suite.Errors++
+			testCase.Error = &junitFailureType{Message: test.Message}
+		case testSkipped:
+			suite.Skipped++
+			testCase.Skipped = &junitSkippedType{Message: test.Message}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+	sort.Strings(names)
+	report := &junitTestSuitesType{}
+	for _, name := range names {
+		report.TestSuites = append(report.TestSuites, suites[name])
+	}
+	_, err := io.WriteString(out, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(out)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(report)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(out, "\n")
+	return err
+}
+
+func writeJUnitFile(filename string, tests []*sampleTestType) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = writeJUnit(file, tests)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func writeSampleTestsText(out io.Writer, tests []*sampleTestType) {
+	for _, test := range tests {
+		if test.Status == testPassed {
+			continue
+		}
+		fmt.Fprintf(out, "%-7s %s %s:%s %s", test.Status, test.ID, test.Ruleset, test.Rule, test.Sample)
+		if test.Message != "" {
+			fmt.Fprintf(out, ": %s", test.Message)
+		}
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintf(out, "%d tests, %d passed, %d failed, %d errors, %d skipped\n", len(tests),
+		countTests(tests, testPassed), countTests(tests, testFailed), countTests(tests, testError), countTests(tests, testSkipped))
+}
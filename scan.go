@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/VirusTotal/gyp/ast"
+)
+
+// A rule prepared for scanning.
+type scanRuleType struct {
+	doc      *yaraRuleType
+	rule     *ast.Rule
+	matchers []stringMatcherType
+	// Why the rule cannot be evaluated, empty if it can
+	unsupported []string
+}
+
+// Rules that matched a file.
+type ruleMatchType struct {
+	ID      string               `json:"id"`
+	Ruleset string               `json:"ruleset"`
+	Rule    string               `json:"rule"`
+	Strings []*stringMatchesType `json:"strings,omitempty"`
+}
+
+type stringMatchesType struct {
+	Identifier string            `json:"identifier"`
+	Matches    []stringMatchType `json:"matches"`
+}
+
+type ruleErrorType struct {
+	ID    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// Result of scanning one file.
+type scanResultType struct {
+	File    string           `json:"file"`
+	Matches []*ruleMatchType `json:"matches"`
+	Errors  []*ruleErrorType `json:"errors,omitempty"`
+}
+
+// Rule that was not evaluated and why.
+type unsupportedRuleType struct {
+	ID      string   `json:"id"`
+	Reasons []string `json:"reasons"`
+}
+
+// Selected rules and the rulesets they belong to. Rules may refer to
+// other rules of their ruleset, so whole rulesets are loaded.
+type scannerType struct {
+	selected []*scanRuleType
+	// Rules by ruleset and rule name
+	rulesets    map[string]map[string]*scanRuleType
+	unsupported map[string]*unsupportedRuleType
+}
+
+func newScanRule(doc *yaraRuleType) *scanRuleType {
+	scanRule := &scanRuleType{doc: doc}
+	rule, err := parseRuleBody(doc.Body)
+	if err != nil {
+		scanRule.unsupported = []string{err.Error()}
+		return scanRule
+	}
+	scanRule.rule = rule
+	scanRule.unsupported = unsupportedFeatures(rule)
+	for _, s := range rule.Strings {
+		matcher, err := newStringMatcher(s)
+		if err != nil {
+			scanRule.unsupported = append(scanRule.unsupported, fmt.Sprintf("string $%s: %v", s.GetIdentifier(), err))
+			continue
+		}
+		scanRule.matchers = append(scanRule.matchers, matcher)
+	}
+	sort.Strings(scanRule.unsupported)
+	return scanRule
+}
+
+// newScanner prepares the given rules for scanning.
+func newScanner(ctx *YaramanContext, docs []*yaraRuleType) (*scannerType, error) {
+	scanner := &scannerType{
+		rulesets:    map[string]map[string]*scanRuleType{},
+		unsupported: map[string]*unsupportedRuleType{},
+	}
+	for _, doc := range docs {
+		rules, err := scanner.loadRuleset(ctx, doc.RulesetName)
+		if err != nil {
+			return nil, err
+		}
+		scanRule, ok := rules[doc.RuleName]
+		if !ok {
+			scanRule = newScanRule(doc)
+			rules[doc.RuleName] = scanRule
+		}
+		scanner.selected = append(scanner.selected, scanRule)
+		if len(scanRule.unsupported) > 0 {
+			scanner.markUnsupported(scanRule, scanRule.unsupported...)
+		}
+	}
+	return scanner, nil
+}
+
+func (scanner *scannerType) loadRuleset(ctx *YaramanContext, rulesetName string) (map[string]*scanRuleType, error) {
+	rules, ok := scanner.rulesets[rulesetName]
+	if ok {
+		return rules, nil
+	}
+	rules = map[string]*scanRuleType{}
+	scanner.rulesets[rulesetName] = rules
+	ids, err := loadList(ctx, fmt.Sprintf(rulesetRulesKey, rulesetName))
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		doc, err := getRuleDoc(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			rules[doc.RuleName] = newScanRule(doc)
+		}
+	}
+	return rules, nil
+}
+
+func (scanner *scannerType) markUnsupported(scanRule *scanRuleType, reasons ...string) {
+	entry, ok := scanner.unsupported[scanRule.doc.ID]
+	if !ok {
+		entry = &unsupportedRuleType{ID: scanRule.doc.ID}
+		scanner.unsupported[scanRule.doc.ID] = entry
+	}
+	for _, reason := range reasons {
+		if !containsString(entry.Reasons, reason) {
+			entry.Reasons = append(entry.Reasons, reason)
+		}
+	}
+}
+
+// unsupportedRules lists the selected rules that could not be evaluated.
+func (scanner *scannerType) unsupportedRules() []*unsupportedRuleType {
+	result := []*unsupportedRuleType{}
+	for _, entry := range scanner.unsupported {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// State of a scan of one file. Rule results and string matches are
+// computed once and shared by rules that refer to each other.
+type fileScanType struct {
+	data    *scanDataType
+	results map[*scanRuleType]bool
+	active  map[*scanRuleType]bool
+	strings map[*scanRuleType]*ruleEvaluatorType
+}
+
+func (scan *fileScanType) evaluatorFor(scanner *scannerType, scanRule *scanRuleType) *ruleEvaluatorType {
+	evaluator, ok := scan.strings[scanRule]
+	if ok {
+		return evaluator
+	}
+	evaluator = &ruleEvaluatorType{
+		data:      scan.data,
+		byKey:     map[string]*stringResultType{},
+		variables: map[string]interface{}{},
+	}
+	for i, matcher := range scanRule.matchers {
+		result := &stringResultType{
+			key:     scanRule.rule.Strings[i].GetIdentifier(),
+			matches: matcher.find(scan.data),
+		}
+		evaluator.strings = append(evaluator.strings, result)
+		evaluator.byKey[result.key] = result
+	}
+	evaluator.evaluateRule = func(name string) (bool, error) {
+		other, ok := scanner.rulesets[scanRule.doc.RulesetName][name]
+		if !ok {
+			return false, fmt.Errorf("undefined identifier %s", name)
+		}
+		return scan.evaluate(scanner, other)
+	}
+	scan.strings[scanRule] = evaluator
+	return evaluator
+}
+
+// evaluate returns whether a rule matches. A rule only matches if all
+// global rules of its ruleset match.
+func (scan *fileScanType) evaluate(scanner *scannerType, scanRule *scanRuleType) (bool, error) {
+	if result, ok := scan.results[scanRule]; ok {
+		return result, nil
+	}
+	if len(scanRule.unsupported) > 0 {
+		return false, unsupported("rule %s: %s", scanRule.doc.RuleName, strings.Join(scanRule.unsupported, ", "))
+	}
+	if scan.active[scanRule] {
+		return false, fmt.Errorf("rule %s refers to itself", scanRule.doc.RuleName)
+	}
+	scan.active[scanRule] = true
+	defer delete(scan.active, scanRule)
+
+	result, err := scan.evaluatorFor(scanner, scanRule).evalBool(scanRule.rule.Condition)
+	if err != nil {
+		return false, err
+	}
+	if result && !scanRule.rule.Global {
+		for _, other := range scanner.rulesets[scanRule.doc.RulesetName] {
+			if other.rule == nil || !other.rule.Global {
+				continue
+			}
+			result, err = scan.evaluate(scanner, other)
+			if err != nil || !result {
+				return false, err
+			}
+		}
+	}
+	scan.results[scanRule] = result
+	return result, nil
+}
+
+func newFileScan(data []byte) *fileScanType {
+	return &fileScanType{
+		data:    newScanData(data),
+		results: map[*scanRuleType]bool{},
+		active:  map[*scanRuleType]bool{},
+		strings: map[*scanRuleType]*ruleEvaluatorType{},
+	}
+}
+
+// matchRule evaluates a single rule against data.
+func (scanner *scannerType) matchRule(scanRule *scanRuleType, data []byte) (bool, error) {
+	return newFileScan(data).evaluate(scanner, scanRule)
+}
+
+// scanData evaluates the selected rules against data. Private rules are
+// evaluated but not reported.
+func (scanner *scannerType) scanData(name string, data []byte) *scanResultType {
+	scan := newFileScan(data)
+	result := &scanResultType{File: name, Matches: []*ruleMatchType{}}
+	for _, scanRule := range scanner.selected {
+		if len(scanRule.unsupported) > 0 {
+			continue
+		}
+		matched, err := scan.evaluate(scanner, scanRule)
+		if err != nil {
+			var unsupportedErr *unsupportedError
+			if errors.As(err, &unsupportedErr) {
+				scanner.markUnsupported(scanRule, unsupportedErr.feature)
+			} else {
+				result.Errors = append(result.Errors, &ruleErrorType{ID: scanRule.doc.ID, Error: err.Error()})
+			}
+			continue
+		}
+		if !matched || scanRule.rule.Private {
+			continue
+		}
+		match := &ruleMatchType{ID: scanRule.doc.ID, Ruleset: scanRule.doc.RulesetName, Rule: scanRule.doc.RuleName}
+		for _, s := range scan.evaluatorFor(scanner, scanRule).strings {
+			if len(s.matches) > 0 {
+				match.Strings = append(match.Strings, &stringMatchesType{Identifier: "$" + s.key, Matches: s.matches})
+			}
+		}
+		result.Matches = append(result.Matches, match)
+	}
+	return result
+}
+
+func (scanner *scannerType) scanFile(filename string) (*scanResultType, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return scanner.scanData(filename, data), nil
+}
+
+// scanPaths scans files and the files in directories, calling callback
+// with the result for each file.
+func (scanner *scannerType) scanPaths(ctx *YaramanContext, paths []string, recursive bool, callback func(*scanResultType) error) error {
+	scanOne := func(ctx *YaramanContext, filename string) error {
+		result, err := scanner.scanFile(filename)
+		if err != nil {
+			errorLogger.Error().Err(err).Str("filename", filename).Msg("Could not scan file.")
+			return nil
+		}
+		return callback(result)
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			err = findFiles(ctx, path, recursive, scanOne)
+		} else {
+			err = scanOne(ctx, filepath.Clean(path))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeStringMatches(out io.Writer, strings []*stringMatchesType) {
+	for _, s := range strings {
+		for _, m := range s.Matches {
+			fmt.Fprintf(out, "  0x%x:%s (%d bytes)\n", m.Offset, s.Identifier, m.Length)
+		}
+	}
+}
+
+func writeScanResultText(out io.Writer, result *scanResultType) {
+	for _, match := range result.Matches {
+		fmt.Fprintf(out, "%s %s\n", match.ID, result.File)
+		writeStringMatches(out, match.Strings)
+	}
+	for _, ruleError := range result.Errors {
+		fmt.Fprintf(out, "error: %s %s: %s\n", ruleError.ID, result.File, ruleError.Error)
+	}
+}
+
+func writeUnsupportedText(out io.Writer, rules []*unsupportedRuleType) {
+	if len(rules) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "%d rules not evaluated:\n", len(rules))
+	for _, rule := range rules {
+		fmt.Fprintf(out, "  %s: %s\n", rule.ID, strings.Join(rule.Reasons, ", "))
+	}
+}
+
+// writeScanJSON writes the scan results and the unsupported rules as one
+// JSON document.
+func writeScanJSON(out io.Writer, results []*scanResultType, unsupportedRules []*unsupportedRuleType) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Results     []*scanResultType      `json:"results"`
+		Unsupported []*unsupportedRuleType `json:"unsupported"`
+	}{results, unsupportedRules})
+}
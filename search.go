@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+const searchPageSize = 1000
+
+// Range of a date field with a date or a time relative to now, like
+// creation_date:>=2020-01-01 or last_modified:>now-90d
+var dateRangeRE = regexp.MustCompile(`(:[<>]=?)(now(?:([+-])(\d+)([dwmy]))?|\d{4}-\d{2}-\d{2})\b`)
+
+// expandDateRanges quotes the dates of range queries, which bleve needs
+// to tell them from numbers, and replaces times relative to now with the
+// date they stand for.
+func expandDateRanges(queryString string, now time.Time) string {
+	return dateRangeRE.ReplaceAllStringFunc(queryString, func(match string) string {
+		parts := dateRangeRE.FindStringSubmatch(match)
+		date := parts[2]
+		if strings.HasPrefix(date, "now") {
+			t := now
+			if parts[3] != "" {
+				n, _ := strconv.Atoi(parts[4])
+				if parts[3] == "-" {
+					n = -n
+				}
+				switch parts[5] {
+				case "d":
+					t = t.AddDate(0, 0, n)
+				case "w":
+					t = t.AddDate(0, 0, 7*n)
+				case "m":
+					t = t.AddDate(0, n, 0)
+				case "y":
+					t = t.AddDate(n, 0, 0)
+				}
+			}
+			date = t.UTC().Format("2006-01-02")
+		}
+		return parts[1] + `"` + date + `"`
+	})
+}
+
+// buildQuery turns a bleve query string into a query. An empty query
+// string matches every rule.
+func buildQuery(queryString string) query.Query {
+	if queryString == "" {
+		return bleve.NewMatchAllQuery()
+	}
+	return bleve.NewQueryStringQuery(expandDateRanges(queryString, time.Now()))
+}
+
+// changedWithinQuery matches rules whose last change is no older than the
+// given number of days.
+func changedWithinQuery(days int) query.Query {
+	start := time.Now().AddDate(0, 0, -days)
+	dateQuery := bleve.NewDateRangeQuery(start, time.Time{})
+	dateQuery.SetField("last_changed")
+	return dateQuery
+}
+
+// searchRuleIDs returns the IDs of all rules matching the query.
+func searchRuleIDs(ctx *YaramanContext, q query.Query) ([]string, error) {
+	ids := []string{}
+	for from := 0; ; from += searchPageSize {
+		request := bleve.NewSearchRequestOptions(ruleDocsQuery(q), searchPageSize, from, false)
+		request.SortBy([]string{"ruleset", "rule"})
+		result, err := ctx.index.Search(request)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range result.Hits {
+			ids = append(ids, hit.ID)
+		}
+		if len(result.Hits) < searchPageSize {
+			break
+		}
+	}
+	return ids, nil
+}
+
+// sortRules reorders rules found in ruleset order by descending quality
+// score or by most recent change. Ties keep their ruleset order.
+func sortRules(docs []*yaraRuleType, by string) {
+	switch by {
+	case "quality":
+		sort.SliceStable(docs, func(i, j int) bool { return docs[i].Quality > docs[j].Quality })
+	case "last_changed":
+		sort.SliceStable(docs, func(i, j int) bool { return docs[i].LastChanged > docs[j].LastChanged })
+	}
+}
+
+// searchRules returns the documents of all rules matching the query.
+func searchRules(ctx *YaramanContext, q query.Query) ([]*yaraRuleType, error) {
+	ids, err := searchRuleIDs(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	docs := []*yaraRuleType{}
+	for _, id := range ids {
+		doc, err := getRuleDoc(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if doc != nil {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func writeSearchResults(out io.Writer, format string, docs []*yaraRuleType) error {
+	if format == "json" {
+		encoder := json.NewEncoder(out)
+		for _, doc := range docs {
+			err := encoder.Encode(doc)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, doc := range docs {
+		fmt.Fprintf(out, "%s  %s  %s\n", doc.ID, doc.RulesetName, doc.RuleName)
+	}
+	return nil
+}
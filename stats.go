@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Number of rules with a value, like the rules of a feed or using a module.
+type valueCountType struct {
+	Value string `json:"value"`
+	Rules int    `json:"rules"`
+}
+
+// Share of rules that have a metadata field.
+type fieldCoverageType struct {
+	Field   string  `json:"field"`
+	Rules   int     `json:"rules"`
+	Percent float64 `json:"percent"`
+}
+
+// Summary of the indexed rules shown by the stats command.
+type corpusStatsType struct {
+	Rules          int                  `json:"rules"`
+	Rulesets       int                  `json:"rulesets"`
+	Private        int                  `json:"private"`
+	Global         int                  `json:"global"`
+	AverageStrings float64              `json:"average_strings"`
+	ParseErrors    int                  `json:"parse_errors"`
+	ByRuleset      []*valueCountType    `json:"by_ruleset"`
+	ByFeed         []*valueCountType    `json:"by_feed"`
+	ByAuthor       []*valueCountType    `json:"by_author"`
+	Modules        []*valueCountType    `json:"modules"`
+	Tags           []*valueCountType    `json:"tags"`
+	Metadata       []*fieldCoverageType `json:"metadata_coverage"`
+}
+
+// valueCounter counts the rules per value. A value is counted once per
+// rule however often the rule has it.
+type valueCounter map[string]int
+
+func (counter valueCounter) add(values ...string) {
+	seen := MapSet{}
+	for _, value := range values {
+		if value != "" && !seen.Contains(value) {
+			seen.Add(value)
+			counter[value]++
+		}
+	}
+}
+
+// top returns the n values with the most rules, all of them if n is not
+// positive.
+func (counter valueCounter) top(n int) []*valueCountType {
+	counts := []*valueCountType{}
+	for value, rules := range counter {
+		counts = append(counts, &valueCountType{value, rules})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Rules != counts[j].Rules {
+			return counts[i].Rules > counts[j].Rules
+		}
+		return counts[i].Value < counts[j].Value
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
+
+// corpusStats summarizes rules, listing the top values of each breakdown.
+// Metadata coverage lists every field.
+func corpusStats(docs []*yaraRuleType, parseErrors int, top int) *corpusStatsType {
+	rulesets := valueCounter{}
+	feeds := valueCounter{}
+	authors := valueCounter{}
+	modules := valueCounter{}
+	tags := valueCounter{}
+	fields := valueCounter{}
+	stats := &corpusStatsType{Rules: len(docs), ParseErrors: parseErrors}
+	stringCount := 0
+	for _, doc := range docs {
+		if doc.Private {
+			stats.Private++
+		}
+		if doc.Global {
+			stats.Global++
+		}
+		stringCount += len(doc.Strings)
+		rulesets.add(doc.RulesetName)
+		feeds.add(doc.Feed)
+		authors.add(doc.Metadata["author"]...)
+		modules.add(doc.Modules...)
+		tags.add(doc.RuleTags...)
+		tags.add(doc.UserTags...)
+		for field := range doc.Metadata {
+			fields.add(field)
+		}
+	}
+	if len(docs) > 0 {
+		stats.AverageStrings = float64(stringCount) / float64(len(docs))
+	}
+	stats.Rulesets = len(rulesets)
+	stats.ByRuleset = rulesets.top(top)
+	stats.ByFeed = feeds.top(top)
+	stats.ByAuthor = authors.top(top)
+	stats.Modules = modules.top(0)
+	stats.Tags = tags.top(top)
+	stats.Metadata = []*fieldCoverageType{}
+	for _, count := range fields.top(0) {
+		percent := 100 * float64(count.Rules) / float64(len(docs))
+		stats.Metadata = append(stats.Metadata, &fieldCoverageType{count.Value, count.Rules, percent})
+	}
+	return stats
+}
+
+// failedRulesets counts the ruleset files whose last import did not
+// parse. Rolled back imports are ignored.
+func failedRulesets(reports []*importReportType) int {
+	failed := map[string]bool{}
+	for _, report := range reports {
+		if report.RolledBack != nil {
+			continue
+		}
+		for _, file := range report.Files {
+			failed[file.Filename] = file.Error != nil
+		}
+	}
+	count := 0
+	for _, isFailed := range failed {
+		if isFailed {
+			count++
+		}
+	}
+	return count
+}
+
+func writeValueCounts(out io.Writer, title string, counts []*valueCountType) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "\n%s\n", title)
+	for _, count := range counts {
+		fmt.Fprintf(out, "  %6d  %s\n", count.Rules, count.Value)
+	}
+}
+
+func writeCorpusStatsText(out io.Writer, stats *corpusStatsType) {
+	fmt.Fprintf(out, "Rules:            %d\n", stats.Rules)
+	fmt.Fprintf(out, "Rulesets:         %d\n", stats.Rulesets)
+	fmt.Fprintf(out, "Private rules:    %d\n", stats.Private)
+	fmt.Fprintf(out, "Global rules:     %d\n", stats.Global)
+	fmt.Fprintf(out, "Strings per rule: %.1f\n", stats.AverageStrings)
+	fmt.Fprintf(out, "Parse errors:     %d\n", stats.ParseErrors)
+	writeValueCounts(out, "Rules per ruleset", stats.ByRuleset)
+	writeValueCounts(out, "Rules per feed", stats.ByFeed)
+	writeValueCounts(out, "Rules per author", stats.ByAuthor)
+	writeValueCounts(out, "Module usage", stats.Modules)
+	writeValueCounts(out, "Top tags", stats.Tags)
+	if len(stats.Metadata) > 0 {
+		fmt.Fprintf(out, "\nMetadata coverage\n")
+		for _, field := range stats.Metadata {
+			fmt.Fprintf(out, "  %5.1f%%  %6d  %s\n", field.Percent, field.Rules, field.Field)
+		}
+	}
+}
+
+func writeCorpusStatsJSON(out io.Writer, stats *corpusStatsType) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(stats)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Namespace of the name based UUIDs of exported objects, so the same rule
+// always gets the same ID
+const stixNamespace = "590e2dc5-16cb-4fe9-b82e-f33d801e1c4d"
+
+const stixTimeFormat = "2006-01-02T15:04:05.000Z"
+
+// Creation time of author identities and of rules without any date, so
+// exporting the same rules always gives the same bundle
+const stixEpoch = "1970-01-01T00:00:00.000Z"
+
+type stixIdentityType struct {
+	Type          string `json:"type"`
+	SpecVersion   string `json:"spec_version"`
+	ID            string `json:"id"`
+	Created       string `json:"created"`
+	Modified      string `json:"modified"`
+	Name          string `json:"name"`
+	IdentityClass string `json:"identity_class"`
+}
+
+// Statement marking naming the license, sources and authors of rules.
+type stixMarkingType struct {
+	Type           string            `json:"type"`
+	SpecVersion    string            `json:"spec_version"`
+	ID             string            `json:"id"`
+	Created        string            `json:"created"`
+	Name           string            `json:"name"`
+	DefinitionType string            `json:"definition_type"`
+	Definition     map[string]string `json:"definition"`
+}
+
+type stixIndicatorType struct {
+	Type              string   `json:"type"`
+	SpecVersion       string   `json:"spec_version"`
+	ID                string   `json:"id"`
+	CreatedByRef      string   `json:"created_by_ref,omitempty"`
+	Created           string   `json:"created"`
+	Modified          string   `json:"modified"`
+	Name              string   `json:"name"`
+	Description       string   `json:"description,omitempty"`
+	Pattern           string   `json:"pattern"`
+	PatternType       string   `json:"pattern_type"`
+	ValidFrom         string   `json:"valid_from"`
+	Labels            []string `json:"labels,omitempty"`
+	ObjectMarkingRefs []string `json:"object_marking_refs,omitempty"`
+}
+
+type stixBundleType struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []interface{} `json:"objects"`
+}
+
+// nameUUID returns the version 5 UUID derived from name.
+func nameUUID(name string) string {
+	namespace, _ := hex.DecodeString(strings.ReplaceAll(stixNamespace, "-", ""))
+	hash := sha1.New()
+	hash.Write(namespace)
+	hash.Write([]byte(name))
+	u := hash.Sum(nil)[:16]
+	u[6] = u[6]&0x0f | 0x50
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+func stixID(objectType, name string) string {
+	return objectType + "--" + nameUUID(name)
+}
+
+// stixTime converts a normalized metadata date to a STIX timestamp.
+func stixTime(date string) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return ""
+	}
+	return t.UTC().Format(stixTimeFormat)
+}
+
+// ruleTimes returns the created and modified timestamps of a rule, from
+// its creation_date and last_modified metadata. Missing dates fall back to
+// each other, then to the last git change, the first import and the epoch.
+func ruleTimes(doc *yaraRuleType) (string, string) {
+	var created, modified string
+	if dates := doc.Metadata["creation_date"]; len(dates) > 0 {
+		created = stixTime(dates[0])
+	}
+	if dates := doc.Metadata["last_modified"]; len(dates) > 0 {
+		modified = stixTime(dates[len(dates)-1])
+	}
+	if created == "" {
+		created = modified
+	}
+	if created == "" {
+		created = stixTime(doc.LastChanged)
+	}
+	if created == "" {
+		created = stixTime(doc.Imported)
+	}
+	if created == "" {
+		created = stixEpoch
+	}
+	// STIX requires modified to be at or after created
+	if modified == "" || modified < created {
+		modified = created
+	}
+	return created, modified
+}
+
+// writeSTIX writes rules as a STIX 2.1 bundle of indicators with YARA
+// patterns. Authors become identities the indicators refer to, and
+// licenses statement markings naming the sources and authors.
+func writeSTIX(out io.Writer, docs []*yaraRuleType, deps map[string][]*yaraRuleType) error {
+	markings := []interface{}{}
+	markingIDs := map[string]string{}
+	for _, attribution := range licenseAttributions(docs) {
+		if attribution.License == "" {
+			continue
+		}
+		// Markings cannot change, so other statements get other IDs
+		marking := &stixMarkingType{
+			Type:           "marking-definition",
+			SpecVersion:    "2.1",
+			ID:             stixID("marking-definition", "license:"+attribution.License+":"+attribution.statement()),
+			Created:        stixEpoch,
+			Name:           attribution.License,
+			DefinitionType: "statement",
+			Definition:     map[string]string{"statement": attribution.statement()},
+		}
+		markings = append(markings, marking)
+		markingIDs[attribution.License] = marking.ID
+	}
+
+	identities := map[string]*stixIdentityType{}
+	indicators := []interface{}{}
+	ids := []string{}
+	for _, doc := range docs {
+		created, modified := ruleTimes(doc)
+		indicator := &stixIndicatorType{
+			Type:        "indicator",
+			SpecVersion: "2.1",
+			ID:          stixID("indicator", doc.ID),
+			Created:     created,
+			Modified:    modified,
+			Name:        doc.RuleName,
+			Description: strings.Join(doc.Metadata["description"], "\n"),
+			Pattern:     ruleSource(doc, deps[doc.ID]),
+			PatternType: "yara",
+			ValidFrom:   created,
+		}
+		labels := MapSet{}
+		labels.AddFromSlice(doc.RuleTags)
+		labels.AddFromSlice(doc.UserTags)
+		indicator.Labels = sortedKeys(labels)
+		if id, ok := markingIDs[doc.License]; ok {
+			indicator.ObjectMarkingRefs = []string{id}
+		}
+
+		if authors := doc.Metadata["author"]; len(authors) > 0 {
+			author := strings.TrimSpace(authors[0])
+			identity, ok := identities[author]
+			if !ok {
+				identity = &stixIdentityType{
+					Type:          "identity",
+					SpecVersion:   "2.1",
+					ID:            stixID("identity", author),
+					Created:       stixEpoch,
+					Modified:      stixEpoch,
+					Name:          author,
+					IdentityClass: "unknown",
+				}
+				identities[author] = identity
+			}
+			indicator.CreatedByRef = identity.ID
+		}
+		indicators = append(indicators, indicator)
+		ids = append(ids, doc.ID)
+	}
+
+	authors := []string{}
+	for author := range identities {
+		authors = append(authors, author)
+	}
+	sort.Strings(authors)
+	objects := markings
+	for _, author := range authors {
+		objects = append(objects, identities[author])
+	}
+	objects = append(objects, indicators...)
+
+	sort.Strings(ids)
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&stixBundleType{
+		Type:    "bundle",
+		ID:      stixID("bundle", strings.Join(ids, ",")),
+		Objects: objects,
+	})
+}
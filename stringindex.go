@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/blevesearch/bleve"
+	"github.com/blevesearch/bleve/search/query"
+)
+
+const (
+	// Bleve type and doc_type value of string sub-documents
+	stringDocKind = "string"
+	// Analyzer for values matched as a whole, ignoring case
+	lowercaseKeywordAnalyzer = "lowercase_keyword"
+)
+
+// A string definition of a rule, indexed as its own document so that
+// queries match the fields of one string rather than of any string of a
+// rule.
+type stringDocType struct {
+	DocType    string   `json:"doc_type"`
+	RuleID     string   `json:"rule_id"`
+	Ruleset    string   `json:"ruleset"`
+	Rule       string   `json:"rule"`
+	Identifier string   `json:"identifier"`
+	Type       string   `json:"type"`
+	Value      string   `json:"value"`
+	Modifiers  []string `json:"modifiers"`
+	// Hex strings without spaces, so that prefixes like 4d5a* can be
+	// searched
+	Hex string `json:"hex,omitempty"`
+}
+
+// BleveType makes bleve use the string document mapping.
+func (doc *stringDocType) BleveType() string {
+	return stringDocKind
+}
+
+func stringDocID(ruleID string, n int) string {
+	return fmt.Sprintf("%s:%d", ruleID, n)
+}
+
+// modifierNames strips arguments from modifiers, so xor(1-3) is indexed
+// as xor as well.
+func modifierNames(modifiers []string) []string {
+	names := []string{}
+	for _, modifier := range modifiers {
+		names = append(names, modifier)
+		if i := strings.Index(modifier, "("); i > 0 {
+			names = append(names, modifier[:i])
+		}
+	}
+	return names
+}
+
+func makeStringDoc(rule *yaraRuleType, def *stringDefType) *stringDocType {
+	doc := &stringDocType{
+		DocType:    stringDocKind,
+		RuleID:     rule.ID,
+		Ruleset:    rule.RulesetName,
+		Rule:       rule.RuleName,
+		Identifier: "$" + def.Identifier,
+		Type:       def.Type,
+		Value:      def.Value,
+		Modifiers:  modifierNames(def.Modifiers),
+	}
+	if def.Type == stringTypeHex {
+		doc.Hex = strings.ToLower(strings.Join(strings.Fields(def.Value), ""))
+	}
+	return doc
+}
+
+// indexStringDocs indexes the string definitions of a rule, removing
+// those of the previous version of the rule that no longer exist.
+func indexStringDocs(batch *bleve.Batch, doc *yaraRuleType, previousCount int) error {
+	for i, def := range doc.Strings {
+		err := batch.Index(stringDocID(doc.ID, i), makeStringDoc(doc, def))
+		if err != nil {
+			return err
+		}
+	}
+	for i := len(doc.Strings); i < previousCount; i++ {
+		batch.Delete(stringDocID(doc.ID, i))
+	}
+	return nil
+}
+
+// ruleDocsQuery restricts a query to rule documents.
+func ruleDocsQuery(q query.Query) query.Query {
+	stringDocs := bleve.NewTermQuery(stringDocKind)
+	stringDocs.SetField("doc_type")
+	ruleDocs := bleve.NewBooleanQuery()
+	ruleDocs.AddMust(q)
+	ruleDocs.AddMustNot(stringDocs)
+	return ruleDocs
+}
+
+// stringsQuery matches the rules that have a string definition matching
+// the query string, which uses the fields of stringDocType.
+func stringsQuery(ctx *YaramanContext, queryString string) (query.Query, error) {
+	docType := bleve.NewTermQuery(stringDocKind)
+	docType.SetField("doc_type")
+	q := bleve.NewConjunctionQuery(bleve.NewQueryStringQuery(queryString), docType)
+
+	ruleIDs := MapSet{}
+	for from := 0; ; from += searchPageSize {
+		request := bleve.NewSearchRequestOptions(q, searchPageSize, from, false)
+		request.SortBy([]string{"_id"})
+		result, err := ctx.index.Search(request)
+		if err != nil {
+			return nil, err
+		}
+		for _, hit := range result.Hits {
+			ruleIDs.Add(hit.ID[:strings.LastIndex(hit.ID, ":")])
+		}
+		if len(result.Hits) < searchPageSize {
+			break
+		}
+	}
+	ids := []string{}
+	for id := range ruleIDs {
+		ids = append(ids, id)
+	}
+	return bleve.NewDocIDQuery(ids), nil
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const ruleVersionsKey = "versions:%s"
+
+// One version of a rule as found in the git history of its ruleset.
+type ruleVersionType struct {
+	Commit string    `json:"commit"`
+	Author string    `json:"author"`
+	Date   time.Time `json:"date"`
+	Body   string    `json:"body"`
+	// Line diff against the previous version, empty for the first one
+	Diff string `json:"diff,omitempty"`
+}
+
+// Rule bodies of one ruleset at one commit.
+type rulesetRevisionType struct {
+	commit *object.Commit
+	bodies map[string]string
+}
+
+// formatDiff formats a line based diff with "+" and "-" prefixes.
+func formatDiff(from string, to string) string {
+	var builder strings.Builder
+	for _, d := range diff.Do(from, to) {
+		prefix := "  "
+		switch d.Type {
+		case diffmatchpatch.DiffInsert:
+			prefix = "+ "
+		case diffmatchpatch.DiffDelete:
+			prefix = "- "
+		}
+		for _, line := range strings.SplitAfter(d.Text, "\n") {
+			if line == "" {
+				continue
+			}
+			builder.WriteString(prefix + strings.TrimSuffix(line, "\n") + "\n")
+		}
+	}
+	return builder.String()
+}
+
+// repoRevisions returns the rule bodies of each ruleset at every commit
+// that changed it, oldest first. The log is walked once and the changes of
+// each commit are bucketed by path, so only the given paths are read.
+// Revisions that do not parse are skipped.
+func repoRevisions(repo *git.Repository, paths MapSet) (map[string][]*rulesetRevisionType, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	commits, err := repo.Log(&git.LogOptions{
+		From:  head.Hash(),
+		Order: git.LogOrderCommitterTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := map[string][]*rulesetRevisionType{}
+	err = commits.ForEach(func(commit *object.Commit) error {
+		tree, err := commit.Tree()
+		if err != nil {
+			return err
+		}
+		var parentTree *object.Tree
+		if commit.NumParents() > 0 {
+			parent, err := commit.Parent(0)
+			if err != nil {
+				return err
+			}
+			parentTree, err = parent.Tree()
+			if err != nil {
+				return err
+			}
+		}
+		changes, err := object.DiffTree(parentTree, tree)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			relativePath := change.To.Name
+			if relativePath == "" || !paths.Contains(relativePath) {
+				// Deleted files have no revision
+				continue
+			}
+			revision, err := readRevision(commit, tree, relativePath)
+			if err != nil {
+				return err
+			}
+			if revision != nil {
+				revisions[relativePath] = append(revisions[relativePath], revision)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The log is newest first, reverse it so commits made in the same
+	// second stay in order
+	for _, pathRevisions := range revisions {
+		for i, j := 0, len(pathRevisions)-1; i < j; i, j = i+1, j-1 {
+			pathRevisions[i], pathRevisions[j] = pathRevisions[j], pathRevisions[i]
+		}
+		sort.SliceStable(pathRevisions, func(i, j int) bool {
+			return pathRevisions[i].commit.Committer.When.Before(pathRevisions[j].commit.Committer.When)
+		})
+	}
+	return revisions, nil
+}
+
+// readRevision parses a ruleset as of a commit, nil if it does not parse.
+func readRevision(commit *object.Commit, tree *object.Tree, relativePath string) (*rulesetRevisionType, error) {
+	file, err := tree.File(relativePath)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, err
+	}
+	ruleset, err := parseYara(contents)
+	if err != nil {
+		logger.Debug().Str("commit", commit.Hash.String()).Str("filename", relativePath).Msg("Skipping revision that does not parse")
+		return nil, nil
+	}
+	revision := &rulesetRevisionType{
+		commit: commit,
+		bodies: map[string]string{},
+	}
+	for _, rule := range ruleset.Rules {
+		var builder strings.Builder
+		rule.WriteSource(&builder)
+		revision.bodies[rule.Identifier] = builder.String()
+	}
+	return revision, nil
+}
+
+// rulesetVersions builds the version history of every rule in a ruleset.
+// A new version is recorded each time the body of a rule changes.
+func rulesetVersions(revisions []*rulesetRevisionType) map[string][]*ruleVersionType {
+	versions := map[string][]*ruleVersionType{}
+	for _, revision := range revisions {
+		for ruleName, body := range revision.bodies {
+			previous := ""
+			if count := len(versions[ruleName]); count > 0 {
+				previous = versions[ruleName][count-1].Body
+				if previous == body {
+					continue
+				}
+			}
+			version := &ruleVersionType{
+				Commit: revision.commit.Hash.String(),
+				Author: revision.commit.Author.Name,
+				Date:   revision.commit.Author.When,
+				Body:   body,
+			}
+			if previous != "" {
+				version.Diff = formatDiff(previous, body)
+			}
+			versions[ruleName] = append(versions[ruleName], version)
+		}
+	}
+	return versions
+}
+
+// Ruleset files of an import in one git repository, by their path
+// relative to the root of the repository
+type repoFilesType struct {
+	repo  *git.Repository
+	files map[string]string
+}
+
+// indexRuleVersions walks the git history of the successfully imported
+// rulesets and stores the versions of their rules. The date of the last
+// change is added to each rule document. Previous versions and documents
+// are kept in the undo information of the import.
+func indexRuleVersions(ctx *YaramanContext, report *importReportType) error {
+	repos := map[string]*repoFilesType{}
+	dirRoots := map[string]string{}
+	for _, file := range report.Files {
+		if file.Error != nil {
+			continue
+		}
+		dir := filepath.Dir(file.Filename)
+		root, ok := dirRoots[dir]
+		if !ok {
+			repo, _ := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{DetectDotGit: true})
+			if repo != nil {
+				worktree, err := repo.Worktree()
+				if err != nil {
+					return err
+				}
+				root = worktree.Filesystem.Root()
+				if repos[root] == nil {
+					repos[root] = &repoFilesType{repo, map[string]string{}}
+				}
+			}
+			dirRoots[dir] = root
+		}
+		if root == "" {
+			continue
+		}
+		absolutePath, err := filepath.Abs(file.Filename)
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(root, absolutePath)
+		if err != nil {
+			return err
+		}
+		repos[root].files[filepath.ToSlash(relativePath)] = file.Filename
+	}
+
+	for root, repoFiles := range repos {
+		paths := MapSet{}
+		for relativePath := range repoFiles.files {
+			paths.Add(relativePath)
+		}
+		revisions, err := repoRevisions(repoFiles.repo, paths)
+		if err != nil {
+			errorLogger.Error().AnErr("error", err).Str("repository", root).Msg("Could not read git history.")
+			continue
+		}
+		for relativePath, filename := range repoFiles.files {
+			for ruleName, ruleVersions := range rulesetVersions(revisions[relativePath]) {
+				err = saveRuleVersions(ctx, report, makeID(filename, ruleName), ruleVersions)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return flushBatch(ctx)
+}
+
+// saveRuleVersions stores the versions of a rule still in the index and
+// sets the date of its last change.
+func saveRuleVersions(ctx *YaramanContext, report *importReportType, id string, ruleVersions []*ruleVersionType) error {
+	doc, err := getRuleDoc(ctx, id)
+	if err != nil || doc == nil {
+		return err
+	}
+	key := fmt.Sprintf(ruleVersionsKey, id)
+	if _, ok := report.undo.Versions[id]; !ok {
+		var previous []*ruleVersionType
+		_, err = loadInternal(ctx, key, &previous)
+		if err != nil {
+			return err
+		}
+		report.undo.Versions[id] = previous
+	}
+	err = saveInternal(ctx, key, ruleVersions)
+	if err != nil {
+		return err
+	}
+	lastChanged := ruleVersions[len(ruleVersions)-1].Date.UTC().Format("2006-01-02")
+	if doc.LastChanged == lastChanged {
+		return nil
+	}
+	report.keepPrevious(doc)
+	doc.LastChanged = lastChanged
+	return indexYaraRule(ctx, doc)
+}
+
+// restoreRuleVersions puts back the versions of a rule as they were before
+// an import. No versions removes the key.
+func restoreRuleVersions(ctx *YaramanContext, id string, versions []*ruleVersionType) error {
+	key := fmt.Sprintf(ruleVersionsKey, id)
+	if len(versions) == 0 {
+		return ctx.index.DeleteInternal([]byte(key))
+	}
+	return saveInternal(ctx, key, versions)
+}
+
+func loadRuleVersions(ctx *YaramanContext, id string) ([]*ruleVersionType, error) {
+	versions := []*ruleVersionType{}
+	_, err := loadInternal(ctx, fmt.Sprintf(ruleVersionsKey, id), &versions)
+	return versions, err
+}
+
+func writeRuleVersions(out io.Writer, doc *yaraRuleType, versions []*ruleVersionType) {
+	fmt.Fprintf(out, "Rule %s (%s) in %s\n", doc.RuleName, doc.ID, doc.RulesetName)
+	if len(versions) == 0 {
+		fmt.Fprintln(out, "No version history.")
+		return
+	}
+	for i, version := range versions {
+		fmt.Fprintf(out, "\nVersion %d  commit %s\nAuthor: %s\nDate:   %s\n",
+			i+1, version.Commit, version.Author, version.Date.Format(time.RFC3339))
+		if version.Diff == "" {
+			fmt.Fprintf(out, "\n%s", version.Body)
+			continue
+		}
+		fmt.Fprintf(out, "\n%s", version.Diff)
+	}
+}
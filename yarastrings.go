@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/VirusTotal/gyp/ast"
+)
+
+const (
+	stringTypeText  = "text"
+	stringTypeHex   = "hex"
+	stringTypeRegex = "regex"
+)
+
+// Structured description of a string definition in a rule.
+type stringDefType struct {
+	Identifier string `json:"identifier"`
+	// One of text, hex or regex
+	Type string `json:"type"`
+	// Value as written in the rule, without quotes or braces
+	Value     string   `json:"value"`
+	Modifiers []string `json:"modifiers"`
+}
+
+func xorModifier(t *ast.TextString) string {
+	switch {
+	case t.XorMin == 0 && t.XorMax == 255:
+		return "xor"
+	case t.XorMin == t.XorMax:
+		return fmt.Sprintf("xor(%d)", t.XorMin)
+	}
+	return fmt.Sprintf("xor(%d-%d)", t.XorMin, t.XorMax)
+}
+
+func base64Modifier(name string, alphabet string) string {
+	if alphabet == "" {
+		return name
+	}
+	return fmt.Sprintf(`%s("%s")`, name, alphabet)
+}
+
+// describeString converts a gyp string definition to a stringDefType.
+// Modifiers are listed in the order gyp writes them.
+func describeString(s ast.String) *stringDefType {
+	def := &stringDefType{
+		Identifier: s.GetIdentifier(),
+		Modifiers:  []string{},
+	}
+	addModifier := func(present bool, modifier string) {
+		if present {
+			def.Modifiers = append(def.Modifiers, modifier)
+		}
+	}
+
+	switch v := s.(type) {
+	case *ast.TextString:
+		def.Type = stringTypeText
+		def.Value = v.Value
+		addModifier(v.ASCII, "ascii")
+		addModifier(v.Wide, "wide")
+		addModifier(v.Nocase, "nocase")
+		addModifier(v.Fullword, "fullword")
+		addModifier(v.Private, "private")
+		addModifier(v.Base64, base64Modifier("base64", v.Base64Alphabet))
+		addModifier(v.Base64Wide, base64Modifier("base64wide", v.Base64Alphabet))
+		addModifier(v.Xor, xorModifier(v))
+	case *ast.HexString:
+		var builder strings.Builder
+		v.Tokens.WriteSource(&builder)
+		def.Type = stringTypeHex
+		def.Value = strings.TrimSpace(builder.String())
+		addModifier(v.Private, "private")
+	case *ast.RegexpString:
+		def.Type = stringTypeRegex
+		def.Value = v.Regexp.String()
+		addModifier(v.ASCII, "ascii")
+		addModifier(v.Wide, "wide")
+		addModifier(v.Nocase, "nocase")
+		addModifier(v.Fullword, "fullword")
+		addModifier(v.Private, "private")
+	}
+	return def
+}
+
+// source returns the definition as it would appear in a rule, without
+// the identifier.
+func (def *stringDefType) source() string {
+	value := def.Value
+	switch def.Type {
+	case stringTypeText:
+		value = `"` + value + `"`
+	case stringTypeHex:
+		value = "{ " + value + " }"
+	}
+	if len(def.Modifiers) == 0 {
+		return value
+	}
+	return value + " " + strings.Join(def.Modifiers, " ")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/VirusTotal/gyp/ast"
+)
+
+// Version of YARA, compared by major and minor version.
+type yaraVersionType struct {
+	Major int
+	Minor int
+}
+
+// Oldest version considered, rules without newer features require it
+var baseYaraVersion = yaraVersionType{3, 0}
+
+// YARA versions that introduced module functions and modules
+var (
+	moduleVersions = map[string]yaraVersionType{
+		"console": {4, 2},
+		"string":  {4, 3},
+	}
+	functionVersions = map[string]yaraVersionType{
+		"math.count":      {4, 2},
+		"math.percentage": {4, 2},
+		"math.mode":       {4, 2},
+	}
+)
+
+func parseYaraVersion(s string) (yaraVersionType, error) {
+	parts := strings.SplitN(strings.TrimPrefix(s, "v"), ".", 3)
+	if len(parts) < 2 {
+		return yaraVersionType{}, fmt.Errorf("invalid YARA version %q, expected major.minor", s)
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil {
+		return yaraVersionType{}, fmt.Errorf("invalid YARA version %q, expected major.minor", s)
+	}
+	return yaraVersionType{major, minor}, nil
+}
+
+func (v yaraVersionType) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+func (v yaraVersionType) less(other yaraVersionType) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
+
+// A feature used by a rule and the YARA version that introduced it.
+type versionRequirementType struct {
+	Feature string
+	Version yaraVersionType
+}
+
+// versionRequirements lists the features of a rule that need a YARA
+// version newer than baseYaraVersion.
+func versionRequirements(rule *ast.Rule) []*versionRequirementType {
+	requirements := []*versionRequirementType{}
+	features := MapSet{}
+	require := func(feature string, version yaraVersionType) {
+		if !features.Contains(feature) {
+			features.Add(feature)
+			requirements = append(requirements, &versionRequirementType{feature, version})
+		}
+	}
+
+	for _, s := range rule.Strings {
+		for _, modifier := range describeString(s).Modifiers {
+			switch {
+			case modifier == "xor":
+				require("xor modifier", yaraVersionType{3, 8})
+			case strings.HasPrefix(modifier, "xor("):
+				require("xor modifier with a range", yaraVersionType{3, 11})
+			case strings.HasPrefix(modifier, "base64"):
+				require("base64 modifiers", yaraVersionType{4, 0})
+			case modifier == "private":
+				require("private strings", yaraVersionType{4, 1})
+			}
+		}
+	}
+
+	walkNode(rule.Condition, func(node ast.Node) {
+		forIn, ok := node.(*ast.ForIn)
+		if !ok {
+			return
+		}
+		switch forIn.Iterator.(type) {
+		case *ast.Range, *ast.Enum:
+		default:
+			require("for loops over arrays and dictionaries", yaraVersionType{4, 0})
+		}
+	})
+	info := analyzeCondition(rule)
+	for _, module := range info.Modules {
+		if version, ok := moduleVersions[module]; ok {
+			require("module "+module, version)
+		}
+	}
+	for _, function := range info.Functions {
+		if version, ok := functionVersions[function]; ok {
+			require("function "+function, version)
+		}
+	}
+
+	sort.SliceStable(requirements, func(i, j int) bool {
+		return requirements[j].Version.less(requirements[i].Version)
+	})
+	return requirements
+}
+
+// minYaraVersion returns the oldest YARA version that supports all the
+// given requirements.
+func minYaraVersion(requirements []*versionRequirementType) yaraVersionType {
+	version := baseYaraVersion
+	for _, requirement := range requirements {
+		if version.less(requirement.Version) {
+			version = requirement.Version
+		}
+	}
+	return version
+}
+
+// unsupportedBy returns the requirements a YARA version does not meet.
+func unsupportedBy(requirements []*versionRequirementType, version yaraVersionType) []string {
+	features := []string{}
+	for _, requirement := range requirements {
+		if version.less(requirement.Version) {
+			features = append(features, fmt.Sprintf("%s (%s)", requirement.Feature, requirement.Version))
+		}
+	}
+	return features
+}